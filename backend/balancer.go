@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 负载均衡策略，对应 chat_balance、codex_balance
+const (
+	// BalanceFailover 默认策略，总是按配置顺序尝试
+	BalanceFailover = "failover"
+	// BalanceRoundRobin 每次请求从下一个上游开始
+	BalanceRoundRobin = "round_robin"
+	// BalanceWeighted 按 weight 随机选择首个上游
+	BalanceWeighted = "weighted"
+	// BalanceLeastLatency 优先选择首字节耗时 EWMA 最低的上游
+	BalanceLeastLatency = "least_latency"
+)
+
+// balancerEWMAAlpha 首字节耗时 EWMA 中新样本的权重
+const balancerEWMAAlpha = 0.3
+
+// 返回 401、429 的上游暂时移出候选列表的时间
+const (
+	balancerUnauthorizedCooldown = 5 * time.Minute
+	balancerRateLimitCooldown    = 30 * time.Second
+)
+
+type upstreamHealth struct {
+	// ttfb 首字节耗时的 EWMA，为 0 表示还没有样本
+	ttfb         time.Duration
+	ejectedUntil time.Time
+}
+
+// balancer 为每个请求排列上游的尝试顺序，并记录各上游（按 key 区分）的延迟和健康状态
+type balancer struct {
+	mu      sync.Mutex
+	next    map[Route]int
+	healths map[string]*upstreamHealth
+}
+
+// upstreamID 区分上游及其 key，用于记录状态，不会输出到日志
+func (up Upstream) id() string {
+	return strings.Join([]string{up.Provider, up.Base, up.Key}, "\x00")
+}
+
+func (b *balancer) health(up Upstream) *upstreamHealth {
+	if b.healths == nil {
+		b.healths = map[string]*upstreamHealth{}
+	}
+	h, ok := b.healths[up.id()]
+	if !ok {
+		h = &upstreamHealth{}
+		b.healths[up.id()] = h
+	}
+	return h
+}
+
+// order 按策略返回本次请求的尝试顺序。被移出的上游排在最后，全部不可用时仍然会尝试。
+func (b *balancer) order(route Route, strategy string, ups []Upstream) []Upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	var healthy, ejected []Upstream
+	for _, up := range ups {
+		if now.Before(b.health(up).ejectedUntil) {
+			ejected = append(ejected, up)
+		} else {
+			healthy = append(healthy, up)
+		}
+	}
+	if len(healthy) > 1 {
+		switch strategy {
+		case BalanceRoundRobin:
+			if b.next == nil {
+				b.next = map[Route]int{}
+			}
+			start := b.next[route] % len(healthy)
+			b.next[route]++
+			healthy = append(append([]Upstream{}, healthy[start:]...), healthy[:start]...)
+		case BalanceWeighted:
+			first := weightedPick(healthy)
+			ordered := make([]Upstream, 0, len(healthy))
+			ordered = append(ordered, healthy[first])
+			ordered = append(ordered, healthy[:first]...)
+			healthy = append(ordered, healthy[first+1:]...)
+		case BalanceLeastLatency:
+			// 没有样本的上游耗时为 0，会优先被尝试一次
+			sort.SliceStable(healthy, func(i, j int) bool {
+				return b.health(healthy[i]).ttfb < b.health(healthy[j]).ttfb
+			})
+		}
+	}
+	return append(healthy, ejected...)
+}
+
+// weightedPick 按权重随机选择一个下标
+func weightedPick(ups []Upstream) int {
+	total := 0
+	for _, up := range ups {
+		total += upstreamWeight(up)
+	}
+	n := rand.Intn(total)
+	for i, up := range ups {
+		n -= upstreamWeight(up)
+		if n < 0 {
+			return i
+		}
+	}
+	return len(ups) - 1
+}
+
+func upstreamWeight(up Upstream) int {
+	if up.Weight <= 0 {
+		return 1
+	}
+	return up.Weight
+}
+
+// observe 记录一次请求的结果：成功时更新首字节耗时，401、429 时暂时移出候选列表
+func (b *balancer) observe(up Upstream, ttfb time.Duration, status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := b.health(up)
+	switch status {
+	case http.StatusUnauthorized:
+		h.ejectedUntil = time.Now().Add(balancerUnauthorizedCooldown)
+		log.Printf("上游 %s 返回 401，%s 内不再使用", up.label(), balancerUnauthorizedCooldown)
+		return
+	case http.StatusTooManyRequests:
+		h.ejectedUntil = time.Now().Add(balancerRateLimitCooldown)
+		log.Printf("上游 %s 返回 429，%s 内不再使用", up.label(), balancerRateLimitCooldown)
+		return
+	}
+	if status != http.StatusOK {
+		return
+	}
+
+	// 重试后成功说明已经恢复，不必等冷却结束
+	h.ejectedUntil = time.Time{}
+	if h.ttfb == 0 {
+		h.ttfb = ttfb
+	} else {
+		h.ttfb = time.Duration(balancerEWMAAlpha*float64(ttfb) + (1-balancerEWMAAlpha)*float64(h.ttfb))
+	}
+}
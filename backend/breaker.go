@@ -0,0 +1,217 @@
+package backend
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState string
+
+const (
+	// BreakerClosed 正常放行请求
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen 失败过多，冷却结束前直接拒绝请求
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen 冷却结束，只放行一个探测请求，成功则恢复，失败则重新打开
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerConfig 熔断策略，对应配置中的 circuit_breaker，failure_rate 为 0 时不启用
+type BreakerConfig struct {
+	// FailureRate 窗口内失败（含慢请求）占比达到该值时打开熔断，取值 0~1
+	FailureRate float64 `json:"failure_rate"`
+	// Window 统计最近多少次请求
+	Window int `json:"window"`
+	// MinRequests 窗口内请求数达到该值后才计算失败率
+	MinRequests int `json:"min_requests"`
+	// SlowCallMs 首字节耗时超过该值的请求按失败计，0 表示不统计慢请求
+	SlowCallMs int `json:"slow_call_ms"`
+	// OpenSeconds 打开后多久进入半开状态
+	OpenSeconds int `json:"open_seconds"`
+}
+
+func (c BreakerConfig) enabled() bool {
+	return c.FailureRate > 0 && c.Window > 0
+}
+
+// BreakerStatus 上游熔断状态，通过 Wails 事件和 UpstreamStatus 提供给前端
+type BreakerStatus struct {
+	Upstream    string       `json:"upstream"`
+	State       BreakerState `json:"state"`
+	FailureRate float64      `json:"failure_rate"`
+	// Since 进入当前状态的时间，Unix 秒
+	Since int64 `json:"since"`
+}
+
+// errAllBreakersOpen 所有上游都处于熔断状态
+var errAllBreakersOpen = errors.New("所有上游均已熔断")
+
+type breaker struct {
+	name     string
+	state    BreakerState
+	since    time.Time
+	outcomes []bool // 环形缓冲，true 表示失败
+	next     int
+	count    int
+	probing  bool
+}
+
+func (b *breaker) failureRate() float64 {
+	if b.count == 0 {
+		return 0
+	}
+	failures := 0
+	for _, failed := range b.outcomes[:b.count] {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(b.count)
+}
+
+func (b *breaker) reset() {
+	b.next, b.count = 0, 0
+}
+
+func (b *breaker) status() BreakerStatus {
+	return BreakerStatus{
+		Upstream:    b.name,
+		State:       b.state,
+		FailureRate: b.failureRate(),
+		Since:       b.since.Unix(),
+	}
+}
+
+// breakers 按上游（含 key）维护熔断器，状态变化时调用 onChange
+type breakers struct {
+	mu       sync.Mutex
+	items    map[string]*breaker
+	onChange func(BreakerStatus)
+}
+
+func (bs *breakers) setListener(fn func(BreakerStatus)) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.onChange = fn
+}
+
+func (bs *breakers) get(cfg BreakerConfig, up Upstream) *breaker {
+	if bs.items == nil {
+		bs.items = map[string]*breaker{}
+	}
+	b, ok := bs.items[up.id()]
+	if !ok || len(b.outcomes) != cfg.Window {
+		b = &breaker{name: up.label(), state: BreakerClosed, since: time.Now(), outcomes: make([]bool, cfg.Window)}
+		bs.items[up.id()] = b
+	}
+	return b
+}
+
+// transition 切换状态并通知监听者，调用方持有锁
+func (bs *breakers) transition(b *breaker, state BreakerState) {
+	if b.state == state {
+		return
+	}
+	log.Printf("上游 %s 熔断状态: %s -> %s", b.name, b.state, state)
+	b.state = state
+	b.since = time.Now()
+	if bs.onChange != nil {
+		status := b.status()
+		go bs.onChange(status)
+	}
+}
+
+// allow 判断是否可以请求该上游，半开状态下同时只放行一个探测请求
+func (bs *breakers) allow(cfg BreakerConfig, up Upstream) bool {
+	if !cfg.enabled() {
+		return true
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b := bs.get(cfg, up)
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.since) < time.Duration(cfg.OpenSeconds)*time.Second {
+			return false
+		}
+		bs.transition(b, BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record 记录一次请求的结果；status 为 0 表示连接失败。
+func (bs *breakers) record(cfg BreakerConfig, up Upstream, ttfb time.Duration, status int) {
+	if !cfg.enabled() {
+		return
+	}
+	failed := status == 0 || status >= http.StatusInternalServerError ||
+		(cfg.SlowCallMs > 0 && ttfb > time.Duration(cfg.SlowCallMs)*time.Millisecond)
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b := bs.get(cfg, up)
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		b.reset()
+		if failed {
+			bs.transition(b, BreakerOpen)
+		} else {
+			bs.transition(b, BreakerClosed)
+		}
+		return
+	}
+
+	b.outcomes[b.next] = failed
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.count < len(b.outcomes) {
+		b.count++
+	}
+	if b.state == BreakerClosed && b.count >= cfg.MinRequests && b.failureRate() >= cfg.FailureRate {
+		bs.transition(b, BreakerOpen)
+	}
+}
+
+// cancelled 记录一次被客户端取消、没有结果的请求。Copilot 每次按键都会取消进行中的补全，
+// 因此上游卡住时请求总是以取消结束：已经超过 slow_call_ms 的按慢请求记为失败，否则只释放半开状态的探测名额。
+func (bs *breakers) cancelled(cfg BreakerConfig, up Upstream, elapsed time.Duration) {
+	if !cfg.enabled() {
+		return
+	}
+	if cfg.SlowCallMs > 0 && elapsed > time.Duration(cfg.SlowCallMs)*time.Millisecond {
+		bs.record(cfg, up, elapsed, 0)
+		return
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.get(cfg, up).probing = false
+}
+
+// statuses 返回所有上游的熔断状态，按名称排序
+func (bs *breakers) statuses() []BreakerStatus {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	list := make([]BreakerStatus, 0, len(bs.items))
+	for _, b := range bs.items {
+		list = append(list, b.status())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Upstream < list[j].Upstream
+	})
+	return list
+}
@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// hangingUpstream 不返回任何响应，直到请求被取消
+func hangingUpstream(t *testing.T) string {
+	return newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后 net/http 才会检测客户端断开
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}).URL
+}
+
+// cancelAfter 模拟 Copilot 在下一次按键时取消进行中的补全请求
+func cancelAfter(s *ProxyService, d time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(d, cancel)
+
+	snapshot := s.current()
+	resp, err := s.forwardFailover(ctx, snapshot, snapshot.cfg.CodexRetry, RouteCodex, snapshot.cfg.codexUpstreams(), []byte(`{}`))
+	if resp != nil {
+		closeIO(resp.Body)
+	}
+	return err
+}
+
+func TestBreakerOpensOnCancelledSlowCalls(t *testing.T) {
+	s := newTestService(t, hangingUpstream(t), func(cfg *config) {
+		cfg.CircuitBreaker = BreakerConfig{FailureRate: 0.5, Window: 4, MinRequests: 2, SlowCallMs: 20, OpenSeconds: 30}
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := cancelAfter(s, 50*time.Millisecond); !errors.Is(err, context.Canceled) {
+			t.Fatalf("第 %d 次请求: 期望 context.Canceled，实际 %v", i+1, err)
+		}
+	}
+
+	start := time.Now()
+	if err := cancelAfter(s, time.Second); !errors.Is(err, errAllBreakersOpen) {
+		t.Fatalf("期望 errAllBreakersOpen，实际 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("熔断后仍然等待了上游 %s", elapsed)
+	}
+
+	statuses := s.breakers.statuses()
+	if len(statuses) != 1 || statuses[0].State != BreakerOpen {
+		t.Errorf("熔断状态 = %+v，期望 open", statuses)
+	}
+}
+
+func TestBreakerIgnoresFastCancellations(t *testing.T) {
+	s := newTestService(t, hangingUpstream(t), func(cfg *config) {
+		cfg.CircuitBreaker = BreakerConfig{FailureRate: 0.5, Window: 4, MinRequests: 2, SlowCallMs: 1000, OpenSeconds: 30}
+	})
+
+	for i := 0; i < 4; i++ {
+		if err := cancelAfter(s, 20*time.Millisecond); !errors.Is(err, context.Canceled) {
+			t.Fatalf("第 %d 次请求: 期望 context.Canceled，实际 %v", i+1, err)
+		}
+	}
+	for _, status := range s.breakers.statuses() {
+		if status.State != BreakerClosed {
+			t.Errorf("未超过 slow_call_ms 的取消不应打开熔断: %+v", status)
+		}
+	}
+}
+
+func TestBreakerHalfOpenProbeTimesOut(t *testing.T) {
+	s := newTestService(t, hangingUpstream(t), func(cfg *config) {
+		cfg.CircuitBreaker = BreakerConfig{FailureRate: 0.5, Window: 4, MinRequests: 1, SlowCallMs: 20, OpenSeconds: 30}
+	})
+	cfg := s.current().cfg.CircuitBreaker
+	up := s.current().cfg.codexUpstreams()[0]
+
+	if err := cancelAfter(s, 50*time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	// 冷却结束，进入半开状态
+	s.breakers.mu.Lock()
+	s.breakers.items[up.id()].since = time.Now().Add(-time.Minute)
+	s.breakers.mu.Unlock()
+
+	if err := cancelAfter(s, 50*time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Fatalf("探测请求: 期望 context.Canceled，实际 %v", err)
+	}
+	if s.breakers.allow(cfg, up) {
+		t.Error("卡住的探测请求被取消后熔断应重新打开")
+	}
+}
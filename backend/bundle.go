@@ -0,0 +1,443 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// bundleFormat 导出文件的格式标识，不带该标识的 JSON 按 override 原版配置导入
+const bundleFormat = "override-gui-bundle"
+
+// bundleVersion 导出文件的格式版本
+const bundleVersion = 1
+
+// 导入方式
+const (
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+)
+
+// configBundle 可分享的配置包，Checksum 为 Config 压缩后内容的 sha256
+type configBundle struct {
+	Format    string          `json:"format"`
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Redacted  bool            `json:"redacted"`
+	Checksum  string          `json:"checksum"`
+	Config    json.RawMessage `json:"config"`
+}
+
+// importedConfig 解析后的导入内容，profile 保留原始 JSON，合并时只覆盖其中出现的字段
+type importedConfig struct {
+	Source        string
+	ActiveProfile string
+	Profiles      map[string]json.RawMessage
+}
+
+// ConfigChange 导入前后单个字段的差异，密钥字段的值已打码
+type ConfigChange struct {
+	Profile string      `json:"profile"`
+	Field   string      `json:"field"`
+	Kind    string      `json:"kind"`
+	Old     interface{} `json:"old,omitempty"`
+	New     interface{} `json:"new,omitempty"`
+}
+
+// ImportPreview 导入预览结果
+type ImportPreview struct {
+	Source  string         `json:"source"`
+	Mode    string         `json:"mode"`
+	Changes []ConfigChange `json:"changes"`
+}
+
+func bundleChecksum(data []byte) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// ExportConfig 导出全部 profile，redact 为 true 时密钥替换为掩码，导入时会保留接收方已有的密钥
+func ExportConfig(redact bool) ResponseData {
+	doc, err := readConfigDocument()
+	if errors.Is(err, os.ErrNotExist) {
+		doc, err = newConfigFile(), nil
+	}
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件读取错误: " + err.Error(),
+		}
+	}
+
+	for name, cfg := range doc.Profiles {
+		if redact {
+			_ = forEachSecret(reflect.ValueOf(&cfg), "", func(_ string, field reflect.Value) error {
+				if field.String() != "" {
+					field.SetString(secretMask)
+				}
+				return nil
+			})
+		} else if err := resolveSecrets(&cfg); err != nil {
+			return ResponseData{
+				Status: "fail",
+				Msg:    "密钥读取错误: " + err.Error(),
+			}
+		}
+		doc.Profiles[name] = cfg
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置导出错误: " + err.Error(),
+		}
+	}
+	checksum, err := bundleChecksum(data)
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置导出错误: " + err.Error(),
+		}
+	}
+
+	bundle, err := json.MarshalIndent(configBundle{
+		Format:    bundleFormat,
+		Version:   bundleVersion,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Redacted:  redact,
+		Checksum:  checksum,
+		Config:    data,
+	}, "", "    ")
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置导出错误: " + err.Error(),
+		}
+	}
+
+	msg := "配置已导出"
+	if !redact {
+		msg = "配置已导出，其中包含明文密钥，请妥善保管"
+	}
+	return ResponseData{
+		Status: "success",
+		Data:   string(bundle),
+		Msg:    msg,
+	}
+}
+
+// parseImport 解析配置包，也接受 override 原版的扁平 config.json 和本程序的配置文件
+func parseImport(data string) (*importedConfig, error) {
+	raw := []byte(strings.TrimSpace(data))
+	if !json.Valid(raw) {
+		return nil, errors.New("导入内容不是有效的 JSON")
+	}
+
+	source := "override"
+	switch {
+	case gjson.GetBytes(raw, "format").String() == bundleFormat:
+		var bundle configBundle
+		if err := json.Unmarshal(raw, &bundle); err != nil {
+			return nil, fmt.Errorf("配置包格式错误: %w", err)
+		}
+		if bundle.Version > bundleVersion {
+			return nil, fmt.Errorf("配置包版本 %d 高于当前程序支持的版本 %d", bundle.Version, bundleVersion)
+		}
+		checksum, err := bundleChecksum(bundle.Config)
+		if err != nil {
+			return nil, fmt.Errorf("配置包格式错误: %w", err)
+		}
+		if checksum != bundle.Checksum {
+			return nil, errors.New("配置包校验失败，内容可能被修改或复制不完整")
+		}
+		raw, source = bundle.Config, bundleFormat
+	case gjson.GetBytes(raw, "profiles").IsObject():
+		source = "override-gui"
+	}
+
+	migrated, _, err := migrateConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		ActiveProfile string                     `json:"active_profile"`
+		Profiles      map[string]json.RawMessage `json:"profiles"`
+	}
+	if err := json.Unmarshal(migrated, &doc); err != nil {
+		return nil, fmt.Errorf("配置格式错误: %w", err)
+	}
+	if len(doc.Profiles) == 0 {
+		return nil, errors.New("导入内容中没有任何配置")
+	}
+	for name, profile := range doc.Profiles {
+		if err := checkProfileName(name); err != nil {
+			return nil, err
+		}
+		if !gjson.ParseBytes(profile).IsObject() {
+			return nil, fmt.Errorf("profile %s 的配置格式错误", name)
+		}
+	}
+	if _, ok := doc.Profiles[doc.ActiveProfile]; !ok {
+		doc.ActiveProfile = ""
+	}
+
+	return &importedConfig{
+		Source:        source,
+		ActiveProfile: doc.ActiveProfile,
+		Profiles:      doc.Profiles,
+	}, nil
+}
+
+// apply 计算导入后的配置。current 中的密钥须为明文，导入内容中的掩码密钥沿用同名 profile 的原值。
+// merge 只覆盖导入内容中出现的 profile 和字段；replace 丢弃现有 profile，缺失的字段使用默认值。
+func (imp *importedConfig) apply(current *configFile, mode string) (*configFile, error) {
+	next := newConfigFile()
+	switch mode {
+	case ImportMerge:
+		for name, cfg := range current.Profiles {
+			next.Profiles[name] = cloneConfig(cfg)
+		}
+		next.ActiveProfile = current.ActiveProfile
+	case ImportReplace:
+		next.ActiveProfile = imp.ActiveProfile
+	default:
+		return nil, fmt.Errorf("不支持的导入方式: %s", mode)
+	}
+
+	for name, profile := range imp.Profiles {
+		cfg, ok := next.Profiles[name]
+		if !ok {
+			cfg = defaultConfig()
+		}
+		if err := json.Unmarshal(profile, &cfg); err != nil {
+			return nil, fmt.Errorf("profile %s 解析错误: %w", name, err)
+		}
+
+		masked := newMaskedSecrets(current.Profiles[name], func(value string) (string, error) {
+			return value, nil
+		})
+		err := forEachSecret(reflect.ValueOf(&cfg), "", func(path string, field reflect.Value) error {
+			switch {
+			case isMaskedSecret(field.String()):
+				original, err := masked.restore(&cfg, path, field.String())
+				if err != nil {
+					return err
+				}
+				field.SetString(original)
+			case isSecretRef(field.String()):
+				return fmt.Errorf("profile %s 的 %s 引用了其他机器的密钥库，请使用导出功能重新导出", name, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if errs := validateConfig(&cfg); len(errs) > 0 {
+			return nil, fmt.Errorf("profile %s 校验失败: %w", name, errs[0])
+		}
+		next.Profiles[name] = cfg
+	}
+
+	if _, ok := next.Profiles[next.ActiveProfile]; !ok {
+		next.ActiveProfile = firstProfile(next)
+	}
+	return next, nil
+}
+
+// firstProfile 优先返回 default，否则返回名称排序后的第一个 profile
+func firstProfile(doc *configFile) string {
+	if _, ok := doc.Profiles[defaultProfileName]; ok {
+		return defaultProfileName
+	}
+	return doc.profileList().Profiles[0]
+}
+
+// resolvedConfigDocument 读取配置文件并将所有 profile 的密钥解析为明文
+func resolvedConfigDocument() (*configFile, error) {
+	doc, err := readConfigDocument()
+	if errors.Is(err, os.ErrNotExist) {
+		return newConfigFile(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for name, cfg := range doc.Profiles {
+		if err := resolveSecrets(&cfg); err != nil {
+			return nil, err
+		}
+		doc.Profiles[name] = cfg
+	}
+	return doc, nil
+}
+
+// diffConfig 逐字段比较两份配置，嵌套的 map 展开为以点分隔的路径
+func diffConfig(before, after *configFile) []ConfigChange {
+	changes := []ConfigChange{}
+	if before.ActiveProfile != after.ActiveProfile {
+		changes = append(changes, ConfigChange{
+			Field: "active_profile",
+			Kind:  "changed",
+			Old:   before.ActiveProfile,
+			New:   after.ActiveProfile,
+		})
+	}
+
+	names := map[string]bool{}
+	for name := range before.Profiles {
+		names[name] = true
+	}
+	for name := range after.Profiles {
+		names[name] = true
+	}
+
+	for name := range names {
+		oldCfg, hadOld := before.Profiles[name]
+		newCfg, hasNew := after.Profiles[name]
+		switch {
+		case !hadOld:
+			changes = append(changes, ConfigChange{Profile: name, Kind: "added"})
+			continue
+		case !hasNew:
+			changes = append(changes, ConfigChange{Profile: name, Kind: "removed"})
+			continue
+		}
+
+		oldFields, newFields := flattenConfig(oldCfg), flattenConfig(newCfg)
+		for field, oldValue := range oldFields {
+			newValue, ok := newFields[field]
+			switch {
+			case !ok:
+				changes = append(changes, ConfigChange{Profile: name, Field: field, Kind: "removed", Old: oldValue})
+			case !reflect.DeepEqual(oldValue, newValue):
+				changes = append(changes, ConfigChange{Profile: name, Field: field, Kind: "changed", Old: oldValue, New: newValue})
+			}
+		}
+		for field, newValue := range newFields {
+			if _, ok := oldFields[field]; !ok {
+				changes = append(changes, ConfigChange{Profile: name, Field: field, Kind: "added", New: newValue})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Profile != changes[j].Profile {
+			return changes[i].Profile < changes[j].Profile
+		}
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// flattenConfig 将配置展开为 字段路径 -> 值，密钥字段打码
+func flattenConfig(cfg config) map[string]interface{} {
+	var value map[string]interface{}
+	data, _ := json.Marshal(maskSecrets(cfg))
+	_ = json.Unmarshal(data, &value)
+
+	out := map[string]interface{}{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			for key, child := range m {
+				walk(prefix+"."+key, child)
+			}
+			return
+		}
+		out[prefix] = v
+	}
+	for key, v := range value {
+		walk(key, v)
+	}
+	return out
+}
+
+// PreviewImport 校验导入内容并返回与当前配置的逐字段差异，不修改配置文件
+func PreviewImport(data, mode string) ResponseData {
+	_, preview, err := prepareImport(data, mode)
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    err.Error(),
+		}
+	}
+
+	return ResponseData{
+		Status: "success",
+		Data:   preview,
+		Msg:    fmt.Sprintf("共 %d 处变更", len(preview.Changes)),
+	}
+}
+
+// ImportConfig 按 merge 或 replace 方式导入配置，新的密钥存入密钥库
+func ImportConfig(data, mode string) ResponseData {
+	next, preview, err := prepareImport(data, mode)
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    err.Error(),
+		}
+	}
+
+	for name, cfg := range next.Profiles {
+		if err := mergeSecrets(&cfg, config{}); err != nil {
+			return ResponseData{
+				Status: "fail",
+				Msg:    "密钥保存错误: " + err.Error(),
+			}
+		}
+		next.Profiles[name] = cfg
+	}
+	if err := writeConfigDocument(next); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件写入错误: " + err.Error(),
+		}
+	}
+
+	return ResponseData{
+		Status: "success",
+		Data:   preview,
+		Msg:    fmt.Sprintf("配置已导入，共 %d 处变更", len(preview.Changes)),
+	}
+}
+
+// prepareImport 解析并应用导入内容，返回导入后的配置（密钥为明文）和差异预览
+func prepareImport(data, mode string) (*configFile, ImportPreview, error) {
+	imp, err := parseImport(data)
+	if err != nil {
+		return nil, ImportPreview{}, err
+	}
+
+	current, err := resolvedConfigDocument()
+	if err != nil {
+		return nil, ImportPreview{}, fmt.Errorf("配置文件读取错误: %w", err)
+	}
+	next, err := imp.apply(current, mode)
+	if err != nil {
+		return nil, ImportPreview{}, err
+	}
+
+	return next, ImportPreview{
+		Source:  imp.Source,
+		Mode:    mode,
+		Changes: diffConfig(current, next),
+	}, nil
+}
@@ -0,0 +1,34 @@
+package backend
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestImportMaskedSecrets(t *testing.T) {
+	current := newConfigFile()
+	cfg := defaultConfig()
+	cfg.ChatUpstreams = []UpstreamConfig{
+		{Name: "a", ApiBase: "https://a.example.com", ApiKey: "sk-upstream-key-a"},
+		{Name: "b", ApiBase: "https://b.example.com", ApiKey: "sk-upstream-key-b"},
+	}
+	current.Profiles[defaultProfileName] = cfg
+
+	// 导出的掩码配置中删除了第一个上游
+	exported := maskSecrets(cfg)
+	exported.ChatUpstreams = exported.ChatUpstreams[1:]
+	profile, _ := json.Marshal(exported)
+	imp := &importedConfig{
+		ActiveProfile: defaultProfileName,
+		Profiles:      map[string]json.RawMessage{defaultProfileName: profile},
+	}
+
+	next, err := imp.apply(current, ImportMerge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ups := next.Profiles[defaultProfileName].ChatUpstreams
+	if len(ups) != 1 || ups[0].Name != "b" || ups[0].ApiKey != "sk-upstream-key-b" {
+		t.Errorf("导入后的上游 = %+v", ups)
+	}
+}
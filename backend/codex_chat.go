@@ -0,0 +1,214 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/tidwall/gjson"
+)
+
+// 代码补全模式
+const (
+	// CodexModeCompletions 默认模式，请求上游的 /completions 接口
+	CodexModeCompletions = "completions"
+	// CodexModeChat 使用 chat 模型补全代码，请求上游的 /chat/completions 接口
+	CodexModeChat = "chat"
+)
+
+// defaultCodexSystemPrompt chat 模式下默认的 system 提示
+const defaultCodexSystemPrompt = "You are a code completion engine. " +
+	"Reply with only the code that should be inserted at the cursor. " +
+	"Do not repeat the code before or after the cursor, do not explain, and do not wrap the answer in markdown fences."
+
+// defaultCodexUserPrompt chat 模式下默认的 user 提示，可用字段见 codexPromptData
+const defaultCodexUserPrompt = "Language: {{.Language}}\n\n" +
+	"<code_before_cursor>\n{{.Prefix}}</code_before_cursor>\n" +
+	"<code_after_cursor>\n{{.Suffix}}</code_after_cursor>\n\n" +
+	"Write the code to insert at the cursor."
+
+// codexPromptData codex_system_prompt 和 codex_user_prompt 模板中可用的字段
+type codexPromptData struct {
+	Prefix   string
+	Suffix   string
+	Language string
+	// Repo 仓库名，形如 owner/repo，请求中没有时为空
+	Repo string
+}
+
+// renderCodexPrompt 渲染提示模板，tmpl 为空时使用 fallback
+func renderCodexPrompt(tmpl, fallback string, data codexPromptData) (string, error) {
+	if tmpl == "" {
+		tmpl = fallback
+	}
+	t, err := template.New("codex").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// constructWithCodeChat 将补全请求的 prompt、suffix 填入提示模板，构造 chat/completions 请求体
+func constructWithCodeChat(body []byte, cfg *config, data codexPromptData) []byte {
+	req := gjson.ParseBytes(body)
+	if data.Language == "" {
+		data.Language = "unknown"
+	}
+
+	system, err := renderCodexPrompt(cfg.CodexSystemPrompt, defaultCodexSystemPrompt, data)
+	if err != nil {
+		log.Println("codex_system_prompt 模板错误，使用默认提示:", err)
+		system, _ = renderCodexPrompt("", defaultCodexSystemPrompt, data)
+	}
+	user, err := renderCodexPrompt(cfg.CodexUserPrompt, defaultCodexUserPrompt, data)
+	if err != nil {
+		log.Println("codex_user_prompt 模板错误，使用默认提示:", err)
+		user, _ = renderCodexPrompt("", defaultCodexUserPrompt, data)
+	}
+
+	out := map[string]interface{}{
+		"model": req.Get("model").String(),
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": user},
+		},
+		"stream": req.Get("stream").Bool(),
+	}
+	for _, key := range []string{"max_tokens", "temperature", "top_p", "stop"} {
+		if v := req.Get(key); v.Exists() && v.Type != gjson.Null {
+			out[key] = json.RawMessage(v.Raw)
+		}
+	}
+
+	chatBody, _ := json.Marshal(out)
+	return chatBody
+}
+
+// fenceStripper 去掉 chat 模型回答首尾的 markdown 代码围栏，支持流式输入
+type fenceStripper struct {
+	started bool
+	buf     string
+}
+
+// write 输入一段文本，返回可以立即输出的部分，可能是结束围栏的内容会暂时保留
+func (f *fenceStripper) write(s string) string {
+	f.buf += s
+	if !f.started {
+		if strings.HasPrefix(f.buf, "```") {
+			// 丢弃开头的 ```lang 整行
+			i := strings.Index(f.buf, "\n")
+			if i < 0 {
+				return ""
+			}
+			f.buf = f.buf[i+1:]
+		} else if strings.HasPrefix("```", f.buf) {
+			return ""
+		}
+		f.started = true
+	}
+
+	// 只看最后一个非空行，结束围栏后面可能还跟着换行
+	rest := strings.TrimRight(f.buf, " \t\r\n")
+	i := strings.LastIndex(rest, "\n")
+	tail := rest[i+1:]
+	if tail == "" || (!strings.HasPrefix(tail, "```") && !strings.HasPrefix("```", tail)) {
+		out := f.buf
+		f.buf = ""
+		return out
+	}
+	if i < 0 {
+		return ""
+	}
+	out := f.buf[:i]
+	f.buf = f.buf[i:]
+	return out
+}
+
+// flush 输入结束，返回剩余文本，末尾的结束围栏被丢弃
+func (f *fenceStripper) flush() string {
+	out := f.buf
+	f.buf = ""
+	if strings.HasPrefix(strings.TrimSpace(out), "```") {
+		return ""
+	}
+	return out
+}
+
+// stripFences 去掉完整回答首尾的代码围栏
+func stripFences(s string) string {
+	var f fenceStripper
+	return f.write(s) + f.flush()
+}
+
+// chatToCompletion 将 chat/completions 的响应转换为 text_completion 格式，流式响应逐段转换
+func chatToCompletion(resp *http.Response) (*http.Response, error) {
+	if isEventStream(resp) {
+		return pipeResponse(resp, "text/event-stream", translateChatToCompletionStream), nil
+	}
+
+	return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+		data := gjson.ParseBytes(body)
+		return json.Marshal(textCompletion(data.Get("id").String(), data.Get("model").String(), data.Get("created").Int(),
+			stripFences(data.Get("choices.0.message.content").String()), data.Get("choices.0.finish_reason").String()))
+	})
+}
+
+// translateChatToCompletionStream 将 chat.completion.chunk 转换为 text_completion 的 SSE
+func translateChatToCompletionStream(src io.Reader, dst io.Writer) error {
+	var completions *completionWriter
+	var stripper fenceStripper
+	finished := false
+
+	finish := func(reason string) error {
+		finished = true
+		return completions.write(stripper.flush(), reason)
+	}
+
+	err := readSSE(src, func(ev sseEvent) error {
+		if completions == nil {
+			completions = newCompletionWriter(dst, gjson.GetBytes(ev.Data, "model").String())
+		}
+		if string(ev.Data) == "[DONE]" {
+			if !finished {
+				return finish("stop")
+			}
+			return nil
+		}
+
+		data := gjson.ParseBytes(ev.Data)
+		if data.Get("error").Exists() {
+			return completions.writeRaw(json.RawMessage(ev.Data))
+		}
+		if text := stripper.write(data.Get("choices.0.delta.content").String()); text != "" {
+			if err := completions.write(text, ""); err != nil {
+				return err
+			}
+		}
+		if reason := data.Get("choices.0.finish_reason").String(); reason != "" && !finished {
+			return finish(reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if completions == nil {
+		completions = newCompletionWriter(dst, "")
+	}
+	if !finished {
+		if err := finish("stop"); err != nil {
+			return err
+		}
+	}
+	return completions.done()
+}
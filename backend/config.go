@@ -0,0 +1,543 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// configFileName 默认配置文件名
+const configFileName = "config.json"
+
+// configFileNames 用户配置目录下按顺序查找的配置文件名，都不存在时使用 config.json
+var configFileNames = []string{configFileName, "config.yaml", "config.yml", "config.toml"}
+
+// configDirName 用户配置目录下的子目录名
+const configDirName = "override-gui"
+
+var (
+	configPathMu   sync.RWMutex
+	configPathFlag string
+)
+
+// SetConfigPath 设置通过 --config 参数指定的配置文件路径
+func SetConfigPath(path string) {
+	configPathMu.Lock()
+	defer configPathMu.Unlock()
+	configPathFlag = path
+}
+
+// ConfigPath 按 --config 参数、OVERRIDE_CONFIG 环境变量、用户配置目录的顺序解析配置文件路径
+func ConfigPath() string {
+	configPathMu.RLock()
+	path := configPathFlag
+	configPathMu.RUnlock()
+	if path != "" {
+		return path
+	}
+
+	if path := os.Getenv("OVERRIDE_CONFIG"); path != "" {
+		return path
+	}
+
+	return defaultConfigPath()
+}
+
+// defaultConfigPath 返回用户配置目录下已存在的配置文件，支持 JSON、YAML 和 TOML
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return configFileName
+	}
+
+	dir = filepath.Join(dir, configDirName)
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, configFileName)
+}
+
+// configPathIsDefault 配置路径是否由程序自动选择，而不是用户通过参数或环境变量指定
+func configPathIsDefault() bool {
+	configPathMu.RLock()
+	defer configPathMu.RUnlock()
+	return configPathFlag == "" && os.Getenv("OVERRIDE_CONFIG") == ""
+}
+
+// readConfigFile 读取配置文件，默认位置不存在时兼容读取工作目录下的旧配置，同时返回实际读取的路径
+func readConfigFile() ([]byte, string, error) {
+	path := ConfigPath()
+	content, err := os.ReadFile(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) || path == configFileName {
+		return content, path, err
+	}
+
+	if legacy, legacyErr := os.ReadFile(configFileName); legacyErr == nil {
+		return legacy, configFileName, nil
+	}
+	return nil, path, err
+}
+
+// loadConfigFile 读取配置文件，统一转换为 JSON 并升级到当前版本，升级前会保留一份 .bak 备份
+func loadConfigFile() ([]byte, error) {
+	content, path, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := decodeConfigData(configFormatOf(path), content)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated, version, err := migrateConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if version == currentConfigVersion {
+		return data, nil
+	}
+
+	log.Printf("配置文件版本 %d 升级到 %d: %s", version, currentConfigVersion, path)
+	if err := writeFileAtomic(path+".bak", content, 0644); err != nil {
+		return nil, fmt.Errorf("备份配置文件失败: %w", err)
+	}
+	if err := writeConfigData(migrated); err != nil {
+		return nil, fmt.Errorf("写入升级后的配置失败: %w", err)
+	}
+
+	return migrated, nil
+}
+
+// writeConfigData 将 JSON 配置按配置文件的格式写回，YAML 和 TOML 会保留原有注释
+func writeConfigData(data []byte) error {
+	path := ConfigPath()
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	fileData, err := encodeConfigData(configFormatOf(path), previous, data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, fileData, 0644)
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免写入中断导致配置损坏
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		closeIO(tmp)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		closeIO(tmp)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, path)
+}
+
+type config struct {
+	Bind                   string            `json:"bind"`
+	ProxyUrl               string            `json:"proxy_url"`
+	Timeout                int               `json:"timeout"`
+	CodexProvider          string            `json:"codex_provider"`
+	CodexApiBase           string            `json:"codex_api_base"`
+	CodexApiKey            string            `json:"codex_api_key" secret:"true"`
+	CodexApiKeys           []string          `json:"codex_api_keys" secret:"true"`
+	CodexApiOrganization   string            `json:"codex_api_organization"`
+	CodexApiProject        string            `json:"codex_api_project"`
+	CodexApiVersion        string            `json:"codex_api_version"`
+	CodexMaxTokens         int               `json:"codex_max_tokens"`
+	CodeInstructModel      string            `json:"code_instruct_model"`
+	CodexMode              string            `json:"codex_mode"`
+	CodexSystemPrompt      string            `json:"codex_system_prompt"`
+	CodexUserPrompt        string            `json:"codex_user_prompt"`
+	FIMTemplates           []FIMTemplate     `json:"fim_templates"`
+	ChatProvider           string            `json:"chat_provider"`
+	ChatApiBase            string            `json:"chat_api_base"`
+	ChatApiKey             string            `json:"chat_api_key" secret:"true"`
+	ChatApiKeys            []string          `json:"chat_api_keys" secret:"true"`
+	ChatApiOrganization    string            `json:"chat_api_organization"`
+	ChatApiProject         string            `json:"chat_api_project"`
+	ChatApiVersion         string            `json:"chat_api_version"`
+	ChatMaxTokens          int               `json:"chat_max_tokens"`
+	ChatModelDefault       string            `json:"chat_model_default"`
+	ChatModelMap           map[string]string `json:"chat_model_map"`
+	ChatLocale             string            `json:"chat_locale"`
+	ChatUpstreams          []UpstreamConfig  `json:"chat_upstreams"`
+	CodexUpstreams         []UpstreamConfig  `json:"codex_upstreams"`
+	ChatBalance            string            `json:"chat_balance"`
+	CodexBalance           string            `json:"codex_balance"`
+	ChatRetry              RetryPolicy       `json:"chat_retry"`
+	CodexRetry             RetryPolicy       `json:"codex_retry"`
+	CircuitBreaker         BreakerConfig     `json:"circuit_breaker"`
+	EmbeddingsProvider     string            `json:"embeddings_provider"`
+	EmbeddingsApiBase      string            `json:"embeddings_api_base"`
+	EmbeddingsApiKey       string            `json:"embeddings_api_key" secret:"true"`
+	EmbeddingsApiVersion   string            `json:"embeddings_api_version"`
+	EmbeddingsModelDefault string            `json:"embeddings_model_default"`
+	EmbeddingsModelMap     map[string]string `json:"embeddings_model_map"`
+	EmbeddingsBatchSize    int               `json:"embeddings_batch_size"`
+	AuthToken              string            `json:"auth_token" secret:"true"`
+}
+
+// UpstreamConfig chat_upstreams、codex_upstreams 中的备用上游，主上游失败时按顺序尝试
+type UpstreamConfig struct {
+	Name            string            `json:"name"`
+	Provider        string            `json:"provider"`
+	ApiBase         string            `json:"api_base"`
+	ApiKey          string            `json:"api_key" secret:"true"`
+	ApiKeys         []string          `json:"api_keys" secret:"true"`
+	ApiOrganization string            `json:"api_organization"`
+	ApiProject      string            `json:"api_project"`
+	ApiVersion      string            `json:"api_version"`
+	ModelMap        map[string]string `json:"model_map"`
+	// Weight weighted 策略下的权重，未配置时为 1
+	Weight int `json:"weight"`
+}
+
+func (u UpstreamConfig) upstream() Upstream {
+	return Upstream{
+		Name:         u.Name,
+		Provider:     u.Provider,
+		Base:         u.ApiBase,
+		Key:          u.ApiKey,
+		Organization: u.ApiOrganization,
+		Project:      u.ApiProject,
+		APIVersion:   u.ApiVersion,
+		ModelMap:     u.ModelMap,
+		Weight:       u.Weight,
+	}
+}
+
+// chatUpstreams 主上游及按顺序排列的备用上游，配置了多个 key 的上游按 key 展开为多个
+func (c *config) chatUpstreams() []Upstream {
+	ups := expandKeys(c.chatUpstream(), c.ChatApiKeys)
+	for _, u := range c.ChatUpstreams {
+		ups = append(ups, expandKeys(u.upstream(), u.ApiKeys)...)
+	}
+	return ups
+}
+
+// codexUpstreams 主上游及按顺序排列的备用上游，配置了多个 key 的上游按 key 展开为多个
+func (c *config) codexUpstreams() []Upstream {
+	ups := expandKeys(c.codexUpstream(), c.CodexApiKeys)
+	for _, u := range c.CodexUpstreams {
+		ups = append(ups, expandKeys(u.upstream(), u.ApiKeys)...)
+	}
+	return ups
+}
+
+// expandKeys 为每个额外的 key 复制一份上游，名称后追加序号以便在日志中区分，不输出 key 本身
+func expandKeys(up Upstream, keys []string) []Upstream {
+	ups := []Upstream{up}
+	for i, key := range keys {
+		if key == "" || key == up.Key {
+			continue
+		}
+		extra := up
+		extra.Key = key
+		extra.Name = fmt.Sprintf("%s#%d", up.label(), i+2)
+		ups = append(ups, extra)
+	}
+	return ups
+}
+
+func (c *config) chatUpstream() Upstream {
+	return Upstream{
+		Provider:     c.ChatProvider,
+		Base:         c.ChatApiBase,
+		Key:          c.ChatApiKey,
+		Organization: c.ChatApiOrganization,
+		Project:      c.ChatApiProject,
+		APIVersion:   c.ChatApiVersion,
+	}
+}
+
+// embeddingsUpstream 未配置 embeddings_api_base 时使用 chat 的上游
+func (c *config) embeddingsUpstream() Upstream {
+	if c.EmbeddingsApiBase == "" {
+		up := c.chatUpstream()
+		up.APIVersion = c.EmbeddingsApiVersion
+		return up
+	}
+	return Upstream{
+		Provider:     c.EmbeddingsProvider,
+		Base:         c.EmbeddingsApiBase,
+		Key:          c.EmbeddingsApiKey,
+		Organization: c.ChatApiOrganization,
+		Project:      c.ChatApiProject,
+		APIVersion:   c.EmbeddingsApiVersion,
+	}
+}
+
+func (c *config) codexUpstream() Upstream {
+	return Upstream{
+		Provider:     c.CodexProvider,
+		Base:         c.CodexApiBase,
+		Key:          c.CodexApiKey,
+		Organization: c.CodexApiOrganization,
+		Project:      c.CodexApiProject,
+		APIVersion:   c.CodexApiVersion,
+	}
+}
+
+// configFile 配置文件结构，包含多个命名 profile 及当前激活的 profile
+type configFile struct {
+	Version       int               `json:"version"`
+	ActiveProfile string            `json:"active_profile"`
+	Profiles      map[string]config `json:"profiles"`
+}
+
+// defaultConfig 新建 profile 时使用的默认配置
+func defaultConfig() config {
+	return config{
+		Bind:              "127.0.0.1:8181",
+		Timeout:           600,
+		CodexProvider:     DefaultProviderName,
+		CodexApiBase:      "https://api.deepseek.com/beta/v1",
+		CodexMaxTokens:    500,
+		CodeInstructModel: DeepSeekCoderModel,
+		CodexMode:         CodexModeCompletions,
+		ChatProvider:      DefaultProviderName,
+		ChatApiBase:       "https://api.deepseek.com/v1",
+		ChatMaxTokens:     4096,
+		ChatModelDefault:  "deepseek-chat",
+		ChatModelMap:      map[string]string{},
+		FIMTemplates:      []FIMTemplate{},
+		ChatLocale:        "zh_CN",
+		ChatUpstreams:     []UpstreamConfig{},
+		CodexUpstreams:    []UpstreamConfig{},
+		ChatRetry:         RetryPolicy{MaxAttempts: 3, BaseDelayMs: 500, MaxDelayMs: 10000},
+		// 代码补全对延迟敏感，只快速重试一次
+		CodexRetry:          RetryPolicy{MaxAttempts: 2, BaseDelayMs: 100, MaxDelayMs: 1000},
+		CircuitBreaker:      BreakerConfig{FailureRate: 0.5, Window: 20, MinRequests: 5, SlowCallMs: 15000, OpenSeconds: 30},
+		EmbeddingsModelMap:  map[string]string{},
+		EmbeddingsBatchSize: embeddingsDefaultBatchSize,
+	}
+}
+
+func newConfigFile() *configFile {
+	return &configFile{
+		Version:       currentConfigVersion,
+		ActiveProfile: defaultProfileName,
+		Profiles:      map[string]config{},
+	}
+}
+
+// readConfigDocument 读取并解析完整的配置文件
+func readConfigDocument() (*configFile, error) {
+	content, err := loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := newConfigFile()
+	if err := json.Unmarshal(content, doc); err != nil {
+		return nil, err
+	}
+	if doc.Profiles == nil {
+		doc.Profiles = map[string]config{}
+	}
+
+	// 旧版本或手动编辑留下的明文密钥移入密钥库
+	if sealed, err := sealPlaintextSecrets(doc); err != nil {
+		log.Println("明文密钥迁移到密钥库失败:", err)
+	} else if sealed {
+		if err := writeConfigDocument(doc); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// writeConfigDocument 将完整的配置文件写回磁盘
+func writeConfigDocument(doc *configFile) error {
+	doc.Version = currentConfigVersion
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := writeConfigData(data); err != nil {
+		return err
+	}
+
+	if err := pruneVault(doc); err != nil {
+		log.Println("清理密钥库失败:", err)
+	}
+	return nil
+}
+
+// cloneConfig 深拷贝配置，避免多个副本共享 map 等引用类型
+func cloneConfig(cfg config) config {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg
+	}
+
+	var out config
+	if err := json.Unmarshal(data, &out); err != nil {
+		return cfg
+	}
+	return out
+}
+
+// activeConfig 返回当前激活的 profile 配置
+func (f *configFile) activeConfig() (config, error) {
+	cfg, ok := f.Profiles[f.ActiveProfile]
+	if !ok {
+		return config{}, fmt.Errorf("profile 不存在: %s", f.ActiveProfile)
+	}
+	return cfg, nil
+}
+
+// configView 返回给前端的配置，EnvFields 列出来自环境变量、应只读展示的字段
+type configView struct {
+	config
+	EnvFields []string `json:"env_fields"`
+}
+
+// loadConfig 读取当前 profile 的完整配置，密钥已解密并应用了环境变量，仅供后端内部使用
+func loadConfig() (config, error) {
+	cfg, _, err := loadConfigWithEnv()
+	return cfg, err
+}
+
+// loadConfigWithEnv 同 loadConfig，额外返回被环境变量覆盖的字段路径
+func loadConfigWithEnv() (config, []string, error) {
+	doc, err := readConfigDocument()
+	if err != nil {
+		return config{}, nil, err
+	}
+	cfg, err := doc.activeConfig()
+	if err != nil {
+		return config{}, nil, err
+	}
+	if err := resolveSecrets(&cfg); err != nil {
+		return config{}, nil, err
+	}
+
+	envFields := applyEnvOverrides(&cfg)
+	return cfg, envFields, nil
+}
+
+// ReadConfig 返回给前端的配置，密钥只以掩码形式出现
+func ReadConfig() ResponseData {
+	cfg, envFields, err := loadConfigWithEnv()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ResponseData{
+				Status: "fail",
+				Data:   nil,
+				Msg:    "无法读取配置文件: " + err.Error(),
+			}
+		}
+		if errors.Is(err, ErrVaultLocked) {
+			return ResponseData{
+				Status: "fail",
+				Data:   nil,
+				Msg:    "密钥库已锁定，请先输入口令解锁",
+			}
+		}
+		return ResponseData{
+			Status: "fail",
+			Data:   nil,
+			Msg:    "配置解析失败: " + err.Error(),
+		}
+	}
+
+	return ResponseData{
+		Status: "success",
+		Data: configView{
+			config:    maskSecrets(cfg),
+			EnvFields: envFields,
+		},
+		Msg: "配置加载成功",
+	}
+}
+
+func UpdateConfig(configData string) ResponseData {
+	var cfg config
+	err := json.Unmarshal([]byte(configData), &cfg)
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置解析错误: " + err.Error(),
+		}
+	}
+
+	if errs := validateConfig(&cfg); len(errs) > 0 {
+		return ResponseData{
+			Status: "fail",
+			Data:   errs,
+			Msg:    "配置校验失败: " + errs[0].Error(),
+		}
+	}
+
+	doc, err := readConfigDocument()
+	if errors.Is(err, os.ErrNotExist) {
+		doc, err = newConfigFile(), nil
+	}
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件读取错误: " + err.Error(),
+		}
+	}
+	restoreEnvFields(&cfg, doc.Profiles[doc.ActiveProfile])
+	if err := mergeSecrets(&cfg, doc.Profiles[doc.ActiveProfile]); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "密钥保存错误: " + err.Error(),
+		}
+	}
+	// cfg 中的密钥已换成密钥库引用，返回给前端的掩码需要按明文生成
+	plain := cloneConfig(cfg)
+	if err := resolveSecrets(&plain); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "密钥读取错误: " + err.Error(),
+		}
+	}
+	doc.Profiles[doc.ActiveProfile] = cfg
+
+	if err := writeConfigDocument(doc); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件写入错误: " + err.Error(),
+		}
+	}
+
+	return ResponseData{
+		Status: "success",
+		Data:   maskSecrets(plain),
+		Msg:    "配置已成功更新",
+	}
+}
@@ -0,0 +1,231 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// embeddingsDefaultBatchSize 未配置 embeddings_batch_size 时每个上游请求包含的 input 数量，
+// 取常见上游中较小的限制（Gemini batchEmbedContents 最多 100 个）
+const embeddingsDefaultBatchSize = 100
+
+// embeddingsModel 按 embeddings_model_map 映射模型名，未命中时使用 embeddings_model_default，均未配置时保持原样
+func (c *config) embeddingsModel(model string) string {
+	if mapped, ok := c.EmbeddingsModelMap[model]; ok {
+		return mapped
+	}
+	if c.EmbeddingsModelDefault != "" {
+		return c.EmbeddingsModelDefault
+	}
+	return model
+}
+
+func (s *ProxyService) embeddings(c *gin.Context) {
+	ctx := c.Request.Context()
+	snapshot := s.current()
+	cfg := snapshot.cfg
+
+	body, err := io.ReadAll(c.Request.Body)
+	if nil != err {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	req := gjson.ParseBytes(body)
+	inputs := splitEmbeddingInput(req.Get("input"))
+	if len(inputs) == 0 {
+		c.Data(http.StatusBadRequest, "application/json", openAIError("input 不能为空", "invalid_request_error"))
+		return
+	}
+	format := req.Get("encoding_format").String()
+	if format != "" && format != "float" && format != "base64" {
+		c.Data(http.StatusBadRequest, "application/json", openAIError("不支持的 encoding_format: "+format, "invalid_request_error"))
+		return
+	}
+
+	model := cfg.embeddingsModel(req.Get("model").String())
+	body, _ = sjson.SetBytes(body, "model", model)
+
+	batchSize := cfg.EmbeddingsBatchSize
+	if batchSize <= 0 {
+		batchSize = embeddingsDefaultBatchSize
+	}
+
+	data := make([]interface{}, 0, len(inputs))
+	var promptTokens, totalTokens int64
+	for start := 0; start < len(inputs); start += batchSize {
+		end := start + batchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+
+		batch := make([]string, 0, end-start)
+		for _, input := range inputs[start:end] {
+			batch = append(batch, input.Raw)
+		}
+		batchBody, _ := sjson.SetRawBytes(body, "input", []byte("["+strings.Join(batch, ",")+"]"))
+
+		result, status, err := requestEmbeddings(ctx, snapshot.client, cfg.embeddingsUpstream(), batchBody)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				c.AbortWithStatus(http.StatusRequestTimeout)
+				return
+			}
+
+			log.Println("request embeddings failed:", err.Error())
+			if status == 0 {
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			c.Data(status, "application/json", result)
+			return
+		}
+
+		response := gjson.ParseBytes(result)
+		for i, item := range response.Get("data").Array() {
+			index := i
+			if v := item.Get("index"); v.Exists() {
+				index = int(v.Int())
+			}
+			embedding, err := convertEmbedding(item.Get("embedding"), format)
+			if err != nil {
+				log.Println("request embeddings failed:", err.Error())
+				c.Data(http.StatusBadGateway, "application/json", openAIError(err.Error(), "upstream_error"))
+				return
+			}
+			data = append(data, gin.H{
+				"object":    "embedding",
+				"index":     start + index,
+				"embedding": embedding,
+			})
+		}
+		if name := response.Get("model").String(); name != "" {
+			model = name
+		}
+		promptTokens += response.Get("usage.prompt_tokens").Int()
+		totalTokens += response.Get("usage.total_tokens").Int()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+		"model":  model,
+		"usage": gin.H{
+			"prompt_tokens": promptTokens,
+			"total_tokens":  totalTokens,
+		},
+	})
+}
+
+// requestEmbeddings 请求一批 embeddings，上游返回非 200 时返回其状态码和响应体
+func requestEmbeddings(ctx context.Context, client *http.Client, up Upstream, body []byte) ([]byte, int, error) {
+	resp, err := forward(ctx, client, RouteEmbeddings, up, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closeIO(resp.Body)
+
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return result, resp.StatusCode, errors.New(upstreamErrorMessage(result))
+	}
+	return result, resp.StatusCode, nil
+}
+
+// splitEmbeddingInput 拆分 input：字符串或单个 token 数组为一项，字符串数组、token 数组的数组逐项拆分
+func splitEmbeddingInput(input gjson.Result) []gjson.Result {
+	if !input.IsArray() {
+		if input.Type == gjson.String {
+			return []gjson.Result{input}
+		}
+		return nil
+	}
+
+	items := input.Array()
+	if len(items) > 0 && items[0].Type == gjson.Number {
+		return []gjson.Result{input}
+	}
+	return items
+}
+
+// embeddingTexts 取出字符串形式的 input，供只接受文本的上游使用
+func embeddingTexts(input gjson.Result) ([]string, error) {
+	var texts []string
+	for _, item := range splitEmbeddingInput(input) {
+		if item.Type != gjson.String {
+			return nil, errors.New("该 provider 只支持字符串形式的 input")
+		}
+		texts = append(texts, item.String())
+	}
+	return texts, nil
+}
+
+// embeddingList 将向量列表包装为 OpenAI embeddings 响应，供非 OpenAI 格式的 Provider 使用
+func embeddingList(model string, vectors []json.RawMessage, promptTokens int64) ([]byte, error) {
+	data := make([]interface{}, 0, len(vectors))
+	for i, vector := range vectors {
+		data = append(data, map[string]interface{}{
+			"object":    "embedding",
+			"index":     i,
+			"embedding": vector,
+		})
+	}
+	return json.Marshal(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  model,
+		"usage": map[string]interface{}{
+			"prompt_tokens": promptTokens,
+			"total_tokens":  promptTokens,
+		},
+	})
+}
+
+// convertEmbedding 按客户端要求的 encoding_format 输出向量。
+// 有的上游忽略 encoding_format 总是返回浮点数组，有的返回 base64 编码的 little-endian float32，两种都可能需要转换。
+func convertEmbedding(embedding gjson.Result, format string) (interface{}, error) {
+	if format == "base64" {
+		if embedding.Type == gjson.String {
+			return embedding.String(), nil
+		}
+		values := embedding.Array()
+		buf := make([]byte, 4*len(values))
+		for i, v := range values {
+			binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(v.Float())))
+		}
+		return base64.StdEncoding.EncodeToString(buf), nil
+	}
+
+	if embedding.IsArray() {
+		return json.RawMessage(embedding.Raw), nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(embedding.String())
+	if err != nil {
+		return nil, fmt.Errorf("无法解析上游返回的向量: %w", err)
+	}
+	if len(raw)%4 != 0 {
+		return nil, fmt.Errorf("上游返回的向量长度无效: %d 字节", len(raw))
+	}
+	values := make([]float32, len(raw)/4)
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,274 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// envPrefix 环境变量覆盖配置时使用的前缀
+const envPrefix = "OVERRIDE_"
+
+// envPathSeparator 环境变量中嵌套字段的分隔符，例如 OVERRIDE_CHAT_MODEL_MAP__GPT_4
+const envPathSeparator = "__"
+
+// applyEnvOverrides 使用环境变量覆盖配置，返回被覆盖字段的 json 路径。
+//
+// OVERRIDE_<字段名> 覆盖整个字段，map、slice 和结构体字段使用 JSON 值；
+// OVERRIDE_<字段名>__<键>__<键> 覆盖嵌套的单个值，map 键优先匹配已有的键，
+// 否则转为小写并将 _ 替换为 -，slice 使用下标，下标等于长度时追加。
+func applyEnvOverrides(cfg *config) []string {
+	var fields []string
+	for _, path := range applyEnvOverridePaths(cfg) {
+		fields = append(fields, strings.Join(path, "."))
+	}
+	return fields
+}
+
+// applyEnvOverridePaths 与 applyEnvOverrides 相同，但按段返回路径，map 键中含有 . 时也不会混淆
+func applyEnvOverridePaths(cfg *config) [][]string {
+	environ := map[string]string{}
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, envPrefix) {
+			environ[name] = value
+		}
+	}
+
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	var paths [][]string
+	for i := 0; i < v.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := envPrefix + strings.ToUpper(tag)
+
+		if value, exists := environ[name]; exists {
+			if err := setEnvValue(v.Field(i), value); err == nil {
+				paths = append(paths, []string{tag})
+			}
+		}
+
+		var nested []string
+		for key := range environ {
+			if strings.HasPrefix(key, name+envPathSeparator) {
+				nested = append(nested, key)
+			}
+		}
+		sort.Strings(nested)
+
+		for _, key := range nested {
+			segments := strings.Split(strings.TrimPrefix(key, name+envPathSeparator), envPathSeparator)
+			path, err := setEnvPath(v.Field(i), segments, environ[key])
+			if err != nil {
+				continue
+			}
+			paths = append(paths, append([]string{tag}, path...))
+		}
+	}
+
+	return paths
+}
+
+// setEnvPath 沿着路径找到嵌套字段并赋值，返回实际使用的 json 路径
+func setEnvPath(v reflect.Value, segments []string, value string) ([]string, error) {
+	if len(segments) == 0 {
+		return nil, setEnvValue(v, value)
+	}
+	segment := segments[0]
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setEnvPath(v.Elem(), segments, value)
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if tag == "" || tag == "-" || strings.ToUpper(tag) != segment {
+				continue
+			}
+			path, err := setEnvPath(v.Field(i), segments[1:], value)
+			return append([]string{tag}, path...), err
+		}
+		return nil, fmt.Errorf("未知字段: %s", segment)
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("不支持的 map 键类型: %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		key := envMapKey(v, segment)
+		keyValue := reflect.ValueOf(key).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(keyValue); existing.IsValid() {
+			elem.Set(existing)
+		}
+		path, err := setEnvPath(elem, segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		v.SetMapIndex(keyValue, elem)
+		return append([]string{key}, path...), nil
+
+	case reflect.Slice:
+		index, err := strconv.Atoi(segment)
+		if err != nil || index < 0 || index > v.Len() {
+			return nil, fmt.Errorf("无效的下标: %s", segment)
+		}
+		if index == v.Len() {
+			v.Set(reflect.Append(v, reflect.New(v.Type().Elem()).Elem()))
+		}
+		path, err := setEnvPath(v.Index(index), segments[1:], value)
+		return append([]string{segment}, path...), err
+	}
+
+	return nil, fmt.Errorf("字段不支持嵌套路径: %s", segment)
+}
+
+// envMapKey 将环境变量中的键还原为 map 的键
+func envMapKey(m reflect.Value, segment string) string {
+	for _, key := range m.MapKeys() {
+		if envName(key.String()) == segment {
+			return key.String()
+		}
+	}
+	return strings.ReplaceAll(strings.ToLower(segment), "_", "-")
+}
+
+// envName 将任意字符串转为环境变量风格：大写，非字母数字替换为 _
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, s)
+}
+
+// setEnvValue 将环境变量的字符串值写入字段，复合类型按 JSON 解析
+func setEnvValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolValue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		intValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(intValue)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		uintValue, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(uintValue)
+	case reflect.Float32, reflect.Float64:
+		floatValue, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(floatValue)
+	case reflect.Map, reflect.Slice, reflect.Struct, reflect.Ptr, reflect.Interface:
+		target := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(value), target.Interface()); err != nil {
+			return err
+		}
+		field.Set(target.Elem())
+	default:
+		return fmt.Errorf("不支持的字段类型: %s", field.Kind())
+	}
+	return nil
+}
+
+// restoreEnvFields 将来自环境变量的字段恢复为文件中的原值，避免前端回传时把环境变量写进配置文件。
+// 只恢复被覆盖的路径，同一字段下其他键的修改会保留。
+func restoreEnvFields(cfg *config, previous config) {
+	probe := cloneConfig(previous)
+	paths := applyEnvOverridePaths(&probe)
+
+	// 从后往前恢复，追加到 slice 末尾的元素先删除，不影响前面的下标
+	for i := len(paths) - 1; i >= 0; i-- {
+		restoreEnvPath(reflect.ValueOf(cfg).Elem(), reflect.ValueOf(previous), paths[i])
+	}
+}
+
+// restoreEnvPath 沿着 json 路径把 dst 中的值恢复为 src 中的值，src 中不存在的 map 键或 slice 元素会从 dst 删除
+func restoreEnvPath(dst, src reflect.Value, path []string) {
+	if len(path) == 0 {
+		dst.Set(src)
+		return
+	}
+	segment := path[0]
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		restoreEnvPath(dst.Elem(), src.Elem(), path)
+
+	case reflect.Struct:
+		t := dst.Type()
+		for i := 0; i < dst.NumField(); i++ {
+			if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == segment {
+				restoreEnvPath(dst.Field(i), src.Field(i), path[1:])
+				return
+			}
+		}
+
+	case reflect.Map:
+		key := reflect.ValueOf(segment).Convert(dst.Type().Key())
+		original := src.MapIndex(key)
+		if !original.IsValid() {
+			if !dst.IsNil() {
+				dst.SetMapIndex(key, reflect.Value{})
+			}
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		elem := reflect.New(dst.Type().Elem()).Elem()
+		if existing := dst.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+		restoreEnvPath(elem, original, path[1:])
+		dst.SetMapIndex(key, elem)
+
+	case reflect.Slice:
+		index, err := strconv.Atoi(segment)
+		if err != nil || index >= dst.Len() {
+			return
+		}
+		if index >= src.Len() {
+			dst.Set(reflect.AppendSlice(dst.Slice(0, index), dst.Slice(index+1, dst.Len())))
+			return
+		}
+		restoreEnvPath(dst.Index(index), src.Index(index), path[1:])
+	}
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRestoreEnvFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		previous func(*config)
+		edit     func(*config)
+		want     func(*config)
+	}{
+		{
+			name: "嵌套键只恢复被覆盖的键",
+			env:  map[string]string{"OVERRIDE_CHAT_MODEL_MAP__GPT_4": "env-model"},
+			previous: func(c *config) {
+				c.ChatModelMap = map[string]string{"gpt-4": "file-model", "gpt-3.5-turbo": "file-turbo"}
+			},
+			edit: func(c *config) {
+				c.ChatModelMap["gpt-3.5-turbo"] = "gui-turbo"
+				c.ChatModelMap["o1"] = "gui-o1"
+			},
+			want: func(c *config) {
+				c.ChatModelMap = map[string]string{"gpt-4": "file-model", "gpt-3.5-turbo": "gui-turbo", "o1": "gui-o1"}
+			},
+		},
+		{
+			name: "环境变量新增的键不写入文件",
+			env:  map[string]string{"OVERRIDE_CHAT_MODEL_MAP__GPT_4O": "env-model"},
+			previous: func(c *config) {
+				c.ChatModelMap = map[string]string{"gpt-4": "file-model"}
+			},
+			edit: func(c *config) {
+				c.ChatModelMap["gpt-4"] = "gui-model"
+			},
+			want: func(c *config) {
+				c.ChatModelMap = map[string]string{"gpt-4": "gui-model"}
+			},
+		},
+		{
+			name: "整个字段覆盖时恢复整个字段",
+			env:  map[string]string{"OVERRIDE_CHAT_MODEL_MAP": `{"gpt-4":"env-model"}`},
+			previous: func(c *config) {
+				c.ChatModelMap = map[string]string{"gpt-4": "file-model", "gpt-3.5-turbo": "file-turbo"}
+			},
+			edit: func(c *config) {
+				c.ChatModelMap["gpt-3.5-turbo"] = "gui-turbo"
+			},
+			want: func(c *config) {
+				c.ChatModelMap = map[string]string{"gpt-4": "file-model", "gpt-3.5-turbo": "file-turbo"}
+			},
+		},
+		{
+			name: "slice 元素的字段只恢复被覆盖的字段",
+			env:  map[string]string{"OVERRIDE_CHAT_UPSTREAMS__0__API_BASE": "https://env.example.com"},
+			previous: func(c *config) {
+				c.ChatUpstreams = []UpstreamConfig{{Name: "file", ApiBase: "https://file.example.com"}}
+			},
+			edit: func(c *config) {
+				c.ChatUpstreams[0].Name = "gui"
+			},
+			want: func(c *config) {
+				c.ChatUpstreams = []UpstreamConfig{{Name: "gui", ApiBase: "https://file.example.com"}}
+			},
+		},
+		{
+			name: "环境变量追加的 slice 元素不写入文件",
+			env:  map[string]string{"OVERRIDE_CHAT_UPSTREAMS__1__NAME": "env"},
+			previous: func(c *config) {
+				c.ChatUpstreams = []UpstreamConfig{{Name: "file"}}
+			},
+			edit: func(c *config) {
+				c.ChatUpstreams[0].Name = "gui"
+			},
+			want: func(c *config) {
+				c.ChatUpstreams = []UpstreamConfig{{Name: "gui"}}
+			},
+		},
+		{
+			name: "顶层字段",
+			env:  map[string]string{"OVERRIDE_CHAT_API_BASE": "https://env.example.com"},
+			previous: func(c *config) {
+				c.ChatApiBase = "https://file.example.com"
+			},
+			edit: func(c *config) {
+				c.ChatModelDefault = "gui-model"
+			},
+			want: func(c *config) {
+				c.ChatApiBase = "https://file.example.com"
+				c.ChatModelDefault = "gui-model"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			previous := defaultConfig()
+			tt.previous(&previous)
+
+			// 前端拿到的是应用了环境变量的配置，修改后整体回传
+			cfg := cloneConfig(previous)
+			applyEnvOverrides(&cfg)
+			tt.edit(&cfg)
+
+			want := cloneConfig(previous)
+			tt.want(&want)
+
+			restoreEnvFields(&cfg, previous)
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("restoreEnvFields() =\n%+v\nwant\n%+v", cfg, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// shouldFailover 上游返回这些状态码时换下一个上游重试，401 通常是该上游的 key 失效
+func shouldFailover(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// mapModel 按上游的 model_map 替换请求中的模型名
+func (up Upstream) mapModel(body []byte) []byte {
+	if len(up.ModelMap) == 0 {
+		return body
+	}
+
+	model := gjson.GetBytes(body, "model").String()
+	mapped, ok := up.ModelMap[model]
+	if !ok {
+		mapped, ok = up.ModelMap["*"]
+	}
+	if !ok {
+		return body
+	}
+	body, _ = sjson.SetBytes(body, "model", mapped)
+	return body
+}
+
+// forwardFailover 按顺序请求上游，每个上游先按重试策略重试，仍然连接失败、5xx、401、429 时换下一个上游；
+// 熔断中的上游直接跳过。切换只发生在拿到响应头之后、向客户端写入任何内容之前。
+// 没有上游成功时返回最后一个失败响应，客户端能看到上游的错误信息；没有任何上游返回响应时返回最后的错误，
+// 所有上游都熔断时返回 errAllBreakersOpen。
+func (s *ProxyService) forwardFailover(ctx context.Context, snapshot *proxySnapshot, policy RetryPolicy, route Route, ups []Upstream, body []byte) (*http.Response, error) {
+	breakerCfg := snapshot.cfg.CircuitBreaker
+	lastErr := errAllBreakersOpen
+	var lastResp *http.Response
+	for i, up := range ups {
+		if !s.breakers.allow(breakerCfg, up) {
+			log.Printf("上游 %s 熔断中，跳过 (%d/%d)", up.label(), i+1, len(ups))
+			continue
+		}
+
+		resp, err := s.forwardRetry(ctx, snapshot, policy, route, up, up.mapModel(body), i < len(ups)-1)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			log.Printf("上游 %s 请求失败 (%d/%d): %s", up.label(), i+1, len(ups), err)
+			lastErr = err
+			continue
+		}
+
+		if shouldFailover(resp.StatusCode) && i < len(ups)-1 {
+			log.Printf("上游 %s 返回 %d (%d/%d)，尝试下一个上游", up.label(), resp.StatusCode, i+1, len(ups))
+			lastResp = bufferResponse(resp)
+			continue
+		}
+		if i > 0 {
+			log.Printf("请求由上游 %s 处理 (%d/%d)", up.label(), i+1, len(ups))
+		}
+		return resp, nil
+	}
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	return nil, lastErr
+}
+
+// bufferResponse 读出失败响应的内容并关闭连接，之后仍可以把该响应返回给客户端
+func bufferResponse(resp *http.Response) *http.Response {
+	body, err := io.ReadAll(resp.Body)
+	closeIO(resp.Body)
+	if err != nil {
+		log.Println("读取上游失败响应失败:", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp
+}
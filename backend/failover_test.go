@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusUpstream 总是返回指定状态码和响应体，并统计请求次数
+func statusUpstream(t *testing.T, status int, body string, hits *atomic.Int32) string {
+	return newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if hits != nil {
+			hits.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}).URL
+}
+
+// openBreaker 让上游的熔断器进入打开状态
+func openBreaker(s *ProxyService, up Upstream) {
+	cfg := s.current().cfg.CircuitBreaker
+	for i := 0; i < cfg.Window; i++ {
+		s.breakers.record(cfg, up, 0, http.StatusInternalServerError)
+	}
+}
+
+func TestFailoverToBackup(t *testing.T) {
+	var primaryHits, backupHits atomic.Int32
+	primary := statusUpstream(t, http.StatusServiceUnavailable, `{"error":{"message":"overloaded"}}`, &primaryHits)
+	backup := statusUpstream(t, http.StatusOK, `{"choices":[]}`, &backupHits)
+	s := newTestService(t, primary, func(cfg *config) {
+		cfg.ChatUpstreams = []UpstreamConfig{{Name: "backup", ApiBase: backup}}
+	})
+
+	snapshot := s.current()
+	resp, err := s.forwardFailover(context.Background(), snapshot, snapshot.cfg.ChatRetry, RouteChat, snapshot.cfg.chatUpstreams(), []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeIO(resp.Body)
+	if resp.StatusCode != http.StatusOK || primaryHits.Load() != 1 || backupHits.Load() != 1 {
+		t.Errorf("status %d, primary %d 次, backup %d 次", resp.StatusCode, primaryHits.Load(), backupHits.Load())
+	}
+}
+
+func TestFailoverKeepsLastResponseWhenRestAreOpen(t *testing.T) {
+	primary := statusUpstream(t, http.StatusServiceUnavailable, `{"error":{"message":"overloaded"}}`, nil)
+	var backupHits atomic.Int32
+	backup := statusUpstream(t, http.StatusOK, `{"choices":[]}`, &backupHits)
+	// newService 主上游返回 503，备用上游处于熔断状态
+	newService := func(t *testing.T) *ProxyService {
+		s := newTestService(t, primary, func(cfg *config) {
+			cfg.ChatUpstreams = []UpstreamConfig{{Name: "backup", ApiBase: backup}}
+			cfg.CodexUpstreams = []UpstreamConfig{{Name: "backup", ApiBase: backup}}
+			cfg.CircuitBreaker = BreakerConfig{FailureRate: 0.5, Window: 2, MinRequests: 2, OpenSeconds: 30}
+		})
+		openBreaker(s, s.current().cfg.chatUpstreams()[1])
+		return s
+	}
+
+	t.Run("forwardFailover", func(t *testing.T) {
+		s := newService(t)
+		snapshot := s.current()
+		resp, err := s.forwardFailover(context.Background(), snapshot, snapshot.cfg.ChatRetry, RouteChat, snapshot.cfg.chatUpstreams(), []byte(`{}`))
+		if errors.Is(err, errAllBreakersOpen) {
+			t.Fatal("已经有上游返回响应时不应返回 errAllBreakersOpen")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		closeIO(resp.Body)
+		if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), "overloaded") {
+			t.Errorf("返回 %d %s，期望主上游的 503 响应", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		s := newService(t)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"messages":[{"role":"user","content":"hi"}]}`))
+		s.completions(c)
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "overloaded") {
+			t.Errorf("chat 返回 %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("codex", func(t *testing.T) {
+		s := newService(t)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/engines/copilot-codex/completions", strings.NewReader(`{"prompt":"a","suffix":"b"}`))
+		s.codeCompletions(c)
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "overloaded") {
+			t.Errorf("codex 返回 %d %s，不应是空的补全", w.Code, w.Body.String())
+		}
+	})
+
+	if backupHits.Load() != 0 {
+		t.Error("熔断中的上游不应被请求")
+	}
+}
+
+func TestFailoverAllBreakersOpen(t *testing.T) {
+	var hits atomic.Int32
+	upstream := statusUpstream(t, http.StatusOK, `{"choices":[]}`, &hits)
+	s := newTestService(t, upstream, func(cfg *config) {
+		cfg.CircuitBreaker = BreakerConfig{FailureRate: 0.5, Window: 2, MinRequests: 2, OpenSeconds: 30}
+	})
+	openBreaker(s, s.current().cfg.chatUpstreams()[0])
+
+	snapshot := s.current()
+	_, err := s.forwardFailover(context.Background(), snapshot, snapshot.cfg.ChatRetry, RouteChat, snapshot.cfg.chatUpstreams(), []byte(`{}`))
+	if !errors.Is(err, errAllBreakersOpen) || hits.Load() != 0 {
+		t.Errorf("err = %v，请求 %d 次", err, hits.Load())
+	}
+}
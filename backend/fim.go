@@ -0,0 +1,181 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// FIMTemplate 按模型名匹配的 FIM（fill-in-the-middle）提示模板。
+// Template 为 Go text/template，可用字段见 codexPromptData。
+type FIMTemplate struct {
+	// Model 模型名的通配符，不区分大小写。* 匹配任意字符（包括 /），? 匹配单个字符，[...] 匹配字符集，
+	// 例如 "*codellama*" 同时匹配 codellama-7b-code 和 codellama/codellama-7b-hf
+	Model    string   `json:"model"`
+	Template string   `json:"template"`
+	Stop     []string `json:"stop"`
+	// Chat 为 true 时把渲染结果作为 user 消息请求 /chat/completions，否则作为 prompt 请求 /completions
+	Chat bool `json:"chat"`
+}
+
+// builtinFIMTemplates 内置的常见模型模板，配置中的 fim_templates 优先匹配
+var builtinFIMTemplates = []FIMTemplate{
+	{
+		Model:    "*codellama*",
+		Template: "<PRE> {{.Prefix}} <SUF>{{.Suffix}} <MID>",
+		Stop:     []string{"<EOT>"},
+	},
+	{
+		Model:    "*qwen*coder*",
+		Template: "<|fim_prefix|>{{.Prefix}}<|fim_suffix|>{{.Suffix}}<|fim_middle|>",
+		Stop:     []string{"<|endoftext|>", "<|fim_pad|>", "<|file_sep|>"},
+	},
+	{
+		Model:    "*codestral*",
+		Template: "[SUFFIX]{{.Suffix}}[PREFIX]{{.Prefix}}",
+		Stop:     []string{"</s>"},
+	},
+	{
+		Model:    "*starcoder*",
+		Template: "{{if .Repo}}<repo_name>{{.Repo}}<file_sep>{{end}}<fim_prefix>{{.Prefix}}<fim_suffix>{{.Suffix}}<fim_middle>",
+		Stop:     []string{"<|endoftext|>", "<file_sep>"},
+	},
+	{
+		// 与早期版本一致请求 /completions，需要走 chat 接口时可在 fim_templates 中配置 chat: true 的同名模板
+		Model:    "*" + StableCodeModelPrefix + "*",
+		Template: "<fim_prefix>{{.Prefix}}<fim_suffix>{{.Suffix}}<fim_middle>",
+		Stop:     []string{"<|endoftext|>"},
+	},
+}
+
+// matchFIMTemplate 按顺序查找第一个匹配模型名的模板，先查配置再查内置模板
+func matchFIMTemplate(templates []FIMTemplate, model string) (FIMTemplate, bool) {
+	for _, list := range [][]FIMTemplate{templates, builtinFIMTemplates} {
+		for _, t := range list {
+			if re, err := compileModelGlob(t.Model); err == nil && re.MatchString(model) {
+				return t, true
+			}
+		}
+	}
+	return FIMTemplate{}, false
+}
+
+// compileModelGlob 将模型名通配符转换为不区分大小写的正则表达式。
+// 与 path.Match 不同，* 也匹配 /，这样 "*starcoder*" 能匹配 vLLM、OpenRouter 使用的 bigcode/starcoder2-15b。
+func compileModelGlob(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("(?i)^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 == len(pattern) {
+				return nil, fmt.Errorf("通配符以 \\ 结尾: %s", pattern)
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("通配符中的 [ 没有闭合: %s", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// render 渲染模板
+func (t FIMTemplate) render(data codexPromptData) (string, error) {
+	tmpl, err := template.New("fim").Parse(t.Template)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// construct 将补全请求的 prompt、suffix 按模板拼接，返回新的请求体。
+// Chat 为 true 时构造 chat/completions 请求体，否则构造不带 suffix 的 completions 请求体。
+func (t FIMTemplate) construct(body []byte, data codexPromptData) ([]byte, error) {
+	prompt, err := t.render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _ = sjson.DeleteBytes(body, "suffix")
+	if stop := mergeStop(gjson.GetBytes(body, "stop"), t.Stop); len(stop) > 0 {
+		body, _ = sjson.SetBytes(body, "stop", stop)
+	}
+	if !t.Chat {
+		body, _ = sjson.SetBytes(body, "prompt", prompt)
+		return body, nil
+	}
+
+	body, _ = sjson.DeleteBytes(body, "prompt")
+	messages := []map[string]string{
+		{
+			"role":    "user",
+			"content": prompt,
+		},
+	}
+	return constructWithChatModel(body, messages), nil
+}
+
+// mergeStop 合并请求中的 stop 与模板的 stop，去除重复项
+func mergeStop(stop gjson.Result, extra []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+
+	if stop.IsArray() {
+		for _, s := range stop.Array() {
+			add(s.String())
+		}
+	} else if stop.Type == gjson.String {
+		add(stop.String())
+	}
+	for _, s := range extra {
+		add(s)
+	}
+	return out
+}
+
+// codexRoute 代码补全请求上游的路由，chat 模式或匹配到 chat 模板时请求 /chat/completions
+func codexRoute(cfg *config) Route {
+	if cfg.CodexMode == CodexModeChat {
+		return RouteChat
+	}
+	if cfg.CodexProvider != "" && cfg.CodexProvider != DefaultProviderName {
+		return RouteCodex
+	}
+	if t, ok := matchFIMTemplate(cfg.FIMTemplates, cfg.CodeInstructModel); ok && t.Chat {
+		return RouteChat
+	}
+	return RouteCodex
+}
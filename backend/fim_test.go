@@ -0,0 +1,223 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// fimRequest Copilot 发出的补全请求，包含 nwo 和语言信息
+const fimRequest = `{"prompt":"def add(a, b):\n","suffix":"\nprint(add(1, 2))\n","max_tokens":1000,"stop":["\n\n"],"stream":true,"nwo":"octo/demo","extra":{"language":"python"}}`
+
+// fimRequestNoRepo 不带 nwo、stop 的补全请求
+const fimRequestNoRepo = `{"prompt":"x","suffix":"y"}`
+
+func TestBuiltinFIMTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     string
+		request   string
+		prompt    string
+		stop      []string
+		wantBody  string
+		wantRoute Route
+	}{
+		{
+			name:      "codellama",
+			model:     "codellama-7b-code",
+			request:   fimRequest,
+			prompt:    "<PRE> def add(a, b):\n <SUF>\nprint(add(1, 2))\n <MID>",
+			stop:      []string{"\n\n", "<EOT>"},
+			wantBody:  `{"model":"codellama-7b-code","prompt":"<PRE> def add(a, b):\n <SUF>\nprint(add(1, 2))\n <MID>","max_tokens":500,"stop":["\n\n","<EOT>"],"stream":true}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "qwen coder",
+			model:     "Qwen2.5-Coder-7B",
+			request:   fimRequest,
+			prompt:    "<|fim_prefix|>def add(a, b):\n<|fim_suffix|>\nprint(add(1, 2))\n<|fim_middle|>",
+			stop:      []string{"\n\n", "<|endoftext|>", "<|fim_pad|>", "<|file_sep|>"},
+			wantBody:  `{"model":"Qwen2.5-Coder-7B","prompt":"<|fim_prefix|>def add(a, b):\n<|fim_suffix|>\nprint(add(1, 2))\n<|fim_middle|>","max_tokens":500,"stop":["\n\n","<|endoftext|>","<|fim_pad|>","<|file_sep|>"],"stream":true}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "codestral",
+			model:     "codestral-latest",
+			request:   fimRequest,
+			prompt:    "[SUFFIX]\nprint(add(1, 2))\n[PREFIX]def add(a, b):\n",
+			stop:      []string{"\n\n", "</s>"},
+			wantBody:  `{"model":"codestral-latest","prompt":"[SUFFIX]\nprint(add(1, 2))\n[PREFIX]def add(a, b):\n","max_tokens":500,"stop":["\n\n","</s>"],"stream":true}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "starcoder 带仓库名",
+			model:     "starcoder2-15b",
+			request:   fimRequest,
+			prompt:    "<repo_name>octo/demo<file_sep><fim_prefix>def add(a, b):\n<fim_suffix>\nprint(add(1, 2))\n<fim_middle>",
+			stop:      []string{"\n\n", "<|endoftext|>", "<file_sep>"},
+			wantBody:  `{"model":"starcoder2-15b","prompt":"<repo_name>octo/demo<file_sep><fim_prefix>def add(a, b):\n<fim_suffix>\nprint(add(1, 2))\n<fim_middle>","max_tokens":500,"stop":["\n\n","<|endoftext|>","<file_sep>"],"stream":true}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "starcoder 不带仓库名",
+			model:     "starcoder2-15b",
+			request:   fimRequestNoRepo,
+			prompt:    "<fim_prefix>x<fim_suffix>y<fim_middle>",
+			stop:      []string{"<|endoftext|>", "<file_sep>"},
+			wantBody:  `{"model":"starcoder2-15b","prompt":"<fim_prefix>x<fim_suffix>y<fim_middle>","stop":["<|endoftext|>","<file_sep>"]}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "stable-code 使用 completions 接口",
+			model:     "stable-code-3b",
+			request:   fimRequest,
+			prompt:    "<fim_prefix>def add(a, b):\n<fim_suffix>\nprint(add(1, 2))\n<fim_middle>",
+			stop:      []string{"\n\n", "<|endoftext|>"},
+			wantBody:  `{"model":"stable-code-3b","prompt":"<fim_prefix>def add(a, b):\n<fim_suffix>\nprint(add(1, 2))\n<fim_middle>","max_tokens":500,"stop":["\n\n","<|endoftext|>"],"stream":true}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "带组织前缀的 qwen coder",
+			model:     "qwen/qwen2.5-coder-7b-instruct",
+			request:   fimRequestNoRepo,
+			prompt:    "<|fim_prefix|>x<|fim_suffix|>y<|fim_middle|>",
+			stop:      []string{"<|endoftext|>", "<|fim_pad|>", "<|file_sep|>"},
+			wantBody:  `{"model":"qwen/qwen2.5-coder-7b-instruct","prompt":"<|fim_prefix|>x<|fim_suffix|>y<|fim_middle|>","stop":["<|endoftext|>","<|fim_pad|>","<|file_sep|>"]}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "带组织前缀的 starcoder",
+			model:     "bigcode/starcoder2-15b",
+			request:   fimRequestNoRepo,
+			prompt:    "<fim_prefix>x<fim_suffix>y<fim_middle>",
+			stop:      []string{"<|endoftext|>", "<file_sep>"},
+			wantBody:  `{"model":"bigcode/starcoder2-15b","prompt":"<fim_prefix>x<fim_suffix>y<fim_middle>","stop":["<|endoftext|>","<file_sep>"]}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "带组织前缀的 codellama",
+			model:     "codellama/CodeLlama-7b-hf",
+			request:   fimRequestNoRepo,
+			prompt:    "<PRE> x <SUF>y <MID>",
+			stop:      []string{"<EOT>"},
+			wantBody:  `{"model":"codellama/CodeLlama-7b-hf","prompt":"<PRE> x <SUF>y <MID>","stop":["<EOT>"]}`,
+			wantRoute: RouteCodex,
+		},
+		{
+			name:      "OpenRouter 的 codestral",
+			model:     "mistralai/codestral-2501",
+			request:   fimRequestNoRepo,
+			prompt:    "[SUFFIX]y[PREFIX]x",
+			stop:      []string{"</s>"},
+			wantBody:  `{"model":"mistralai/codestral-2501","prompt":"[SUFFIX]y[PREFIX]x","stop":["</s>"]}`,
+			wantRoute: RouteCodex,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.CodeInstructModel = tt.model
+
+			body := ConstructRequestBody([]byte(tt.request), &cfg)
+			assertJSONEqual(t, body, tt.wantBody)
+
+			prompt := gjson.GetBytes(body, "prompt")
+			if tt.wantRoute == RouteChat {
+				prompt = gjson.GetBytes(body, "messages.0.content")
+			}
+			if prompt.String() != tt.prompt {
+				t.Errorf("prompt = %q，期望 %q", prompt.String(), tt.prompt)
+			}
+			var stop []string
+			for _, s := range gjson.GetBytes(body, "stop").Array() {
+				stop = append(stop, s.String())
+			}
+			if !reflect.DeepEqual(stop, tt.stop) {
+				t.Errorf("stop = %q，期望 %q", stop, tt.stop)
+			}
+			if route := codexRoute(&cfg); route != tt.wantRoute {
+				t.Errorf("route = %s，期望 %s", route, tt.wantRoute)
+			}
+		})
+	}
+}
+
+func TestUserFIMTemplateOverridesBuiltin(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.FIMTemplates = []FIMTemplate{{
+		Model:    "codellama-*",
+		Template: "<s><PRE>{{.Prefix}}<SUF>{{.Suffix}}<MID>",
+		Stop:     []string{"</s>"},
+	}}
+
+	cfg.CodeInstructModel = "codellama-13b-code"
+	body := ConstructRequestBody([]byte(fimRequestNoRepo), &cfg)
+	assertJSONEqual(t, body, `{"model":"codellama-13b-code","prompt":"<s><PRE>x<SUF>y<MID>","stop":["</s>"]}`)
+
+	// 不符合前缀的模型仍使用内置模板
+	cfg.CodeInstructModel = "my-codellama"
+	body = ConstructRequestBody([]byte(fimRequestNoRepo), &cfg)
+	assertJSONEqual(t, body, `{"model":"my-codellama","prompt":"<PRE> x <SUF>y <MID>","stop":["<EOT>"]}`)
+}
+
+func TestFIMTemplatesSkippedForOtherModels(t *testing.T) {
+	cfg := defaultConfig()
+	body := ConstructRequestBody([]byte(`{"prompt":"x","suffix":"y","n":3}`), &cfg)
+	assertJSONEqual(t, body, `{"model":"deepseek-coder","prompt":"x","suffix":"y","n":1}`)
+}
+
+func TestStableCodeChatOptIn(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CodeInstructModel = "stabilityai/stable-code-3b"
+	cfg.FIMTemplates = []FIMTemplate{{
+		Model:    "*stable-code*",
+		Template: "<fim_prefix>{{.Prefix}}<fim_suffix>{{.Suffix}}<fim_middle>",
+		Stop:     []string{"<|endoftext|>"},
+		Chat:     true,
+	}}
+
+	body := ConstructRequestBody([]byte(fimRequestNoRepo), &cfg)
+	assertJSONEqual(t, body, `{"model":"stabilityai/stable-code-3b","messages":[{"role":"user","content":"<fim_prefix>x<fim_suffix>y<fim_middle>"}],"stop":["<|endoftext|>"]}`)
+	if route := codexRoute(&cfg); route != RouteChat {
+		t.Errorf("route = %s，期望 %s", route, RouteChat)
+	}
+}
+
+func TestCompileModelGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		model   string
+		want    bool
+	}{
+		{"*qwen*coder*", "qwen/qwen2.5-coder-7b-instruct", true},
+		{"*starcoder*", "bigcode/starcoder2-15b", true},
+		{"*codellama*", "codellama/codellama-7b-hf", true},
+		{"*codellama*", "CodeLlama-7B", true},
+		{"codellama-*", "my-codellama-7b", false},
+		{"deepseek-coder-?.?b", "deepseek-coder-1.3b", true},
+		{"deepseek-coder-?.?b", "deepseek-coder-6.7xb", false},
+		{"gpt-4o.mini", "gpt-4oxmini", false},
+		{"model-[0-9]", "model-7", true},
+		{"model-[!0-9]", "model-7", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+	}
+
+	for _, tt := range tests {
+		re, err := compileModelGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.model); got != tt.want {
+			t.Errorf("%s 匹配 %s = %v，期望 %v", tt.pattern, tt.model, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"model-[0-9", `model\`} {
+		if _, err := compileModelGlob(pattern); err == nil {
+			t.Errorf("%s 应返回错误", pattern)
+		}
+	}
+}
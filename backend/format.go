@@ -0,0 +1,294 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// 支持的配置文件格式
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// configFormatOf 根据扩展名判断配置文件格式，未知扩展名按 JSON 处理
+func configFormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// formatExt 返回格式对应的文件扩展名
+func formatExt(format string) (string, error) {
+	switch format {
+	case FormatJSON:
+		return ".json", nil
+	case FormatYAML:
+		return ".yaml", nil
+	case FormatTOML:
+		return ".toml", nil
+	}
+	return "", fmt.Errorf("不支持的配置格式: %s", format)
+}
+
+// decodeConfigData 将任意格式的配置内容转换为 JSON，后续的迁移和解析统一基于 JSON
+func decodeConfigData(format string, data []byte) ([]byte, error) {
+	var value map[string]interface{}
+	switch format {
+	case FormatJSON:
+		return data, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的配置格式: %s", format)
+	}
+
+	if value == nil {
+		value = map[string]interface{}{}
+	}
+	return json.Marshal(value)
+}
+
+// encodeConfigData 将 JSON 配置写成目标格式。previous 为磁盘上已有的内容，
+// YAML 和 TOML 会在其基础上修改，保留用户的注释和键顺序。
+func encodeConfigData(format string, previous, data []byte) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		return formatJSON(data)
+	case FormatYAML:
+		return encodeYAML(previous, data)
+	case FormatTOML:
+		return encodeTOML(previous, data)
+	}
+	return nil, fmt.Errorf("不支持的配置格式: %s", format)
+}
+
+// formatJSON 以与 UpdateConfig 一致的缩进格式化 JSON
+func formatJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "    "); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ConvertConfig 将配置文件转换为指定格式，写入同目录下扩展名不同的文件并返回新路径。
+// 配置路径由程序自动选择时，原文件改名为 .bak，使新文件立即生效；
+// 通过 --config 或 OVERRIDE_CONFIG 指定路径时只生成新文件，需要用户自行修改路径。
+func ConvertConfig(format string) ResponseData {
+	ext, err := formatExt(strings.ToLower(format))
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    err.Error(),
+		}
+	}
+
+	content, path, err := readConfigFile()
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件读取错误: " + err.Error(),
+		}
+	}
+	from := configFormatOf(path)
+	to := configFormatOf("config" + ext)
+	if from == to {
+		return ResponseData{
+			Status: "success",
+			Data:   path,
+			Msg:    "配置文件已经是 " + to + " 格式",
+		}
+	}
+
+	// 经过 configFile 重新编码，转换后的键顺序与 JSON 配置一致
+	var doc configFile
+	data, err := decodeConfigData(from, content)
+	if err == nil {
+		data, _, err = migrateConfig(data)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件解析错误: " + err.Error(),
+		}
+	}
+	doc.Version = currentConfigVersion
+	if data, err = json.Marshal(doc); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置格式转换失败: " + err.Error(),
+		}
+	}
+	converted, err := encodeConfigData(to, nil, data)
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置格式转换失败: " + err.Error(),
+		}
+	}
+
+	target := strings.TrimSuffix(path, filepath.Ext(path)) + ext
+	if _, err := os.Stat(target); err == nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "目标文件已存在: " + target,
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件写入错误: " + err.Error(),
+		}
+	}
+	if err := writeFileAtomic(target, converted, 0644); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件写入错误: " + err.Error(),
+		}
+	}
+
+	if !configPathIsDefault() || path == configFileName {
+		return ResponseData{
+			Status: "success",
+			Data:   target,
+			Msg:    "已生成 " + target + "，请将配置路径改为该文件",
+		}
+	}
+	if err := os.Rename(path, path+".bak"); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "备份原配置文件失败: " + err.Error(),
+		}
+	}
+
+	return ResponseData{
+		Status: "success",
+		Data:   target,
+		Msg:    "配置文件已转换为 " + to + " 格式",
+	}
+}
+
+func encodeYAML(previous, data []byte) ([]byte, error) {
+	// JSON 是 YAML 的子集，解析为节点树后键顺序与 JSON 一致
+	var src yaml.Node
+	if err := yaml.Unmarshal(data, &src); err != nil {
+		return nil, err
+	}
+
+	var dst yaml.Node
+	if len(bytes.TrimSpace(previous)) == 0 {
+		dst = *restyleYAML(&src)
+	} else if err := yaml.Unmarshal(previous, &dst); err != nil {
+		log.Println("YAML 配置文件解析失败，无法保留注释，将整体重写配置文件:", err)
+		dst = *restyleYAML(&src)
+	} else if dst.Kind != yaml.DocumentNode {
+		dst = *restyleYAML(&src)
+	} else {
+		mergeYAML(&dst, &src)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&dst); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mergeYAML 将 src 的值合并进 dst：已有的键保留位置和注释，新键追加在末尾，多余的键删除
+func mergeYAML(dst, src *yaml.Node) {
+	if dst.Kind != src.Kind {
+		head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+		*dst = *restyleYAML(src)
+		dst.HeadComment, dst.LineComment, dst.FootComment = head, line, foot
+		return
+	}
+
+	switch dst.Kind {
+	case yaml.DocumentNode:
+		if len(dst.Content) > 0 && len(src.Content) > 0 {
+			mergeYAML(dst.Content[0], src.Content[0])
+		}
+	case yaml.MappingNode:
+		index := map[string]int{}
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			index[dst.Content[i].Value] = i
+		}
+
+		seen := map[string]bool{}
+		var appended []*yaml.Node
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key := src.Content[i].Value
+			seen[key] = true
+			if j, ok := index[key]; ok {
+				mergeYAML(dst.Content[j+1], src.Content[i+1])
+			} else {
+				appended = append(appended, restyleYAML(src.Content[i]), restyleYAML(src.Content[i+1]))
+			}
+		}
+
+		content := make([]*yaml.Node, 0, len(dst.Content)+len(appended))
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			if seen[dst.Content[i].Value] {
+				content = append(content, dst.Content[i], dst.Content[i+1])
+			}
+		}
+		dst.Content = append(content, appended...)
+	case yaml.SequenceNode:
+		for i, item := range src.Content {
+			if i < len(dst.Content) {
+				mergeYAML(dst.Content[i], item)
+			} else {
+				dst.Content = append(dst.Content, restyleYAML(item))
+			}
+		}
+		dst.Content = dst.Content[:len(src.Content)]
+	case yaml.ScalarNode:
+		if dst.Tag != src.Tag {
+			dst.Style = 0
+		}
+		dst.Value = src.Value
+		dst.Tag = src.Tag
+	default:
+		*dst = *restyleYAML(src)
+	}
+}
+
+// restyleYAML 复制节点并清除从 JSON 带来的引号和流式风格，输出为普通的块风格 YAML
+func restyleYAML(node *yaml.Node) *yaml.Node {
+	out := *node
+	out.Style = 0
+	out.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		out.Content[i] = restyleYAML(child)
+	}
+	return &out
+}
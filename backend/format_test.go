@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// captureLog 在 fn 执行期间收集日志输出
+func captureLog(fn func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(io.Discard)
+	fn()
+	return buf.String()
+}
+
+// assertRoundTrip 写出的文件重新解析后应与写入的 JSON 一致
+func assertRoundTrip(t *testing.T, format string, out []byte, data string) {
+	t.Helper()
+	decoded, err := decodeConfigData(format, out)
+	if err != nil {
+		t.Fatalf("重新解析失败: %v\n%s", err, out)
+	}
+	assertJSONEqual(t, decoded, data)
+}
+
+const tomlPrevious = `# 配置文件
+version = 2
+active_profile = "default" # 当前使用的 profile
+
+# 默认 profile
+[profiles.default]
+bind = "127.0.0.1:8181"
+chat_model_map = { gpt-4 = "deepseek-chat", gpt-3 = "deepseek-chat" }
+chat_api_keys = [
+  "vault:a", # 第一个 key
+  "vault:b",
+]
+timeout = 600 # 秒
+
+[profiles.default.embeddings_model_map]
+ada = "bge"
+
+[profiles.work]
+bind = '127.0.0.1:9000'
+`
+
+func TestEncodeTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "内容不变时原样保留",
+			data: `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181","chat_model_map":{"gpt-4":"deepseek-chat","gpt-3":"deepseek-chat"},"chat_api_keys":["vault:a","vault:b"],"timeout":600,"embeddings_model_map":{"ada":"bge"}},"work":{"bind":"127.0.0.1:9000"}}}`,
+			want: tomlPrevious,
+		},
+		{
+			name: "修改值时保留注释和键顺序",
+			data: `{"version":2,"active_profile":"work","profiles":{"default":{"bind":"127.0.0.1:8181","chat_model_map":{"gpt-4":"deepseek-chat","gpt-3":"deepseek-chat"},"chat_api_keys":["vault:a","vault:b"],"timeout":300,"embeddings_model_map":{"ada":"bge"}},"work":{"bind":"127.0.0.1:9001"}}}`,
+			want: strings.NewReplacer(
+				`active_profile = "default"`, `active_profile = "work"`,
+				`timeout = 600`, `timeout = 300`,
+				`bind = '127.0.0.1:9000'`, `bind = "127.0.0.1:9001"`,
+			).Replace(tomlPrevious),
+		},
+		{
+			name: "内联表和多行数组整体替换",
+			data: `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181","chat_model_map":{"gpt-4":"deepseek-chat","gpt-4o":"deepseek-chat"},"chat_api_keys":["vault:a"],"timeout":600,"embeddings_model_map":{"ada":"bge"}},"work":{"bind":"127.0.0.1:9000"}}}`,
+			want: strings.NewReplacer(
+				`{ gpt-4 = "deepseek-chat", gpt-3 = "deepseek-chat" }`, `{ gpt-4 = "deepseek-chat", gpt-4o = "deepseek-chat" }`,
+				"[\n  \"vault:a\", # 第一个 key\n  \"vault:b\",\n]", `["vault:a"]`,
+			).Replace(tomlPrevious),
+		},
+		{
+			name: "新增和删除键",
+			data: `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181","chat_model_map":{"gpt-4":"deepseek-chat","gpt-3":"deepseek-chat"},"chat_api_keys":["vault:a","vault:b"],"chat_locale":"en_US","embeddings_model_map":{"ada":"bge","text-embedding-3-small":"bge"}},"work":{"bind":"127.0.0.1:9000"}}}`,
+			want: strings.NewReplacer(
+				"timeout = 600 # 秒\n", "chat_locale = \"en_US\"\n",
+				"ada = \"bge\"\n", "ada = \"bge\"\ntext-embedding-3-small = \"bge\"\n",
+			).Replace(tomlPrevious),
+		},
+		{
+			name: "新增和删除 profile 表",
+			data: `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181","chat_model_map":{"gpt-4":"deepseek-chat","gpt-3":"deepseek-chat"},"chat_api_keys":["vault:a","vault:b"],"timeout":600,"embeddings_model_map":{"ada":"bge"}},"home":{"bind":"127.0.0.1:9100","chat_model_map":{"gpt-4":"qwen"}}}}`,
+			want: strings.Replace(tomlPrevious, "\n[profiles.work]\nbind = '127.0.0.1:9000'\n", "\n", 1) +
+				"\n[profiles.home]\nbind = \"127.0.0.1:9100\"\n\n[profiles.home.chat_model_map]\ngpt-4 = \"qwen\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := encodeTOML([]byte(tomlPrevious), []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("输出:\n%s\n期望:\n%s", out, tt.want)
+			}
+			assertRoundTrip(t, FormatTOML, out, tt.data)
+		})
+	}
+}
+
+func TestEncodeTOMLArrayTableFallback(t *testing.T) {
+	previous := "# 注释会丢失\nversion = 2\n\n[[servers]]\nname = \"a\"\n"
+	data := `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181"}}}`
+
+	var out []byte
+	logs := captureLog(func() {
+		var err error
+		if out, err = encodeTOML([]byte(previous), []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(logs, "[[表数组]]") {
+		t.Errorf("整体重写时应输出警告，日志: %q", logs)
+	}
+	want := "version = 2\nactive_profile = \"default\"\n\n[profiles.default]\nbind = \"127.0.0.1:8181\"\n"
+	if string(out) != want {
+		t.Errorf("输出:\n%s\n期望:\n%s", out, want)
+	}
+	assertRoundTrip(t, FormatTOML, out, data)
+}
+
+func TestEncodeTOMLNewFile(t *testing.T) {
+	data := `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181","chat_api_keys":["vault:a"],"chat_model_map":{}}}}`
+	logs := captureLog(func() {
+		out, err := encodeTOML(nil, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertRoundTrip(t, FormatTOML, out, data)
+	})
+	if logs != "" {
+		t.Errorf("新文件不应输出警告: %q", logs)
+	}
+}
+
+const yamlPrevious = `# 配置文件
+version: 2
+active_profile: default # 当前使用的 profile
+profiles:
+  # 默认 profile
+  default:
+    bind: 127.0.0.1:8181 # 监听地址
+    timeout: 600
+    chat_api_keys:
+      - vault:a # 第一个 key
+      - vault:b
+`
+
+func TestEncodeYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "内容不变时原样保留",
+			data: `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181","timeout":600,"chat_api_keys":["vault:a","vault:b"]}}}`,
+			want: yamlPrevious,
+		},
+		{
+			name: "修改值时保留注释和键顺序",
+			data: `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:9000","timeout":300,"chat_api_keys":["vault:a","vault:b"]}}}`,
+			want: strings.NewReplacer("127.0.0.1:8181", "127.0.0.1:9000", "timeout: 600", "timeout: 300").Replace(yamlPrevious),
+		},
+		{
+			name: "新增和删除键",
+			data: `{"version":2,"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181","chat_api_keys":["vault:a"],"chat_model_map":{"gpt-4":"deepseek-chat"}},"work":{"bind":"127.0.0.1:9000"}}}`,
+			want: `# 配置文件
+version: 2
+active_profile: default # 当前使用的 profile
+profiles:
+  # 默认 profile
+  default:
+    bind: 127.0.0.1:8181 # 监听地址
+    chat_api_keys:
+      - vault:a # 第一个 key
+    chat_model_map:
+      gpt-4: deepseek-chat
+  work:
+    bind: 127.0.0.1:9000
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := encodeYAML([]byte(yamlPrevious), []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("输出:\n%s\n期望:\n%s", out, tt.want)
+			}
+			assertRoundTrip(t, FormatYAML, out, tt.data)
+		})
+	}
+}
+
+func TestEncodeYAMLInvalidPrevious(t *testing.T) {
+	data := `{"version":2,"active_profile":"default","profiles":{}}`
+	logs := captureLog(func() {
+		out, err := encodeYAML([]byte("version: [2\n"), []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertRoundTrip(t, FormatYAML, out, data)
+	})
+	if !strings.Contains(logs, "无法保留注释") {
+		t.Errorf("整体重写时应输出警告，日志: %q", logs)
+	}
+}
@@ -0,0 +1,599 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// 这里只实现保留注释所需的最小 TOML 扫描：定位表头和键值对所在的字节范围，
+// 值的解析仍交给 go-toml 完成。
+
+// errTOMLArrayTable 已有文件使用了 [[表数组]]，无法按行修改
+var errTOMLArrayTable = errors.New("toml array of tables")
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// orderedEntry/orderedObject 保持 JSON 对象键顺序的解码结果
+type orderedEntry struct {
+	Key   string
+	Value interface{}
+}
+
+type orderedObject []orderedEntry
+
+func (o orderedObject) get(key string) (interface{}, bool) {
+	for _, entry := range o {
+		if entry.Key == key {
+			return entry.Value, true
+		}
+	}
+	return nil, false
+}
+
+// decodeOrdered 按原始键顺序解码 JSON，对象解码为 orderedObject，数字保留为 json.Number
+func decodeOrdered(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeOrderedValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("JSON 末尾存在多余内容")
+	}
+	return value, nil
+}
+
+func decodeOrderedValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		obj := orderedObject{}
+		for dec.More() {
+			keyToken, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, orderedEntry{Key: keyToken.(string), Value: value})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return token, nil
+}
+
+// tomlEntry 文件中的一个键值对，path 包含所在表的路径
+type tomlEntry struct {
+	path       []string
+	start, end int // 整行范围，包含换行符
+	valueStart int
+	valueEnd   int
+}
+
+// tomlTable 文件中的一个表头
+type tomlTable struct {
+	path       []string
+	start, end int // 表头所在行
+	lastEnd    int // 表内最后一个键值对的结束位置，新键插入在这里
+}
+
+type tomlScanner struct {
+	data []byte
+	pos  int
+}
+
+// scanTOML 扫描 TOML 文本，返回所有键值对和表头的位置
+func scanTOML(data []byte) ([]tomlEntry, []tomlTable, int, error) {
+	s := &tomlScanner{data: data}
+	var entries []tomlEntry
+	var tables []tomlTable
+	var current []string
+	rootEnd := 0
+	tableIndex := -1
+
+	for s.pos < len(data) {
+		lineStart := s.pos
+		s.skipSpaces()
+		if s.pos >= len(data) {
+			break
+		}
+
+		switch data[s.pos] {
+		case '\n', '\r':
+			s.pos++
+			continue
+		case '#':
+			s.skipLine()
+			continue
+		case '[':
+			if s.pos+1 < len(data) && data[s.pos+1] == '[' {
+				return nil, nil, 0, errTOMLArrayTable
+			}
+			s.pos++
+			path, err := s.parseKey(']')
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			s.pos++
+			s.skipLine()
+			current = path
+			tables = append(tables, tomlTable{path: path, start: lineStart, end: s.pos, lastEnd: s.pos})
+			tableIndex = len(tables) - 1
+			continue
+		}
+
+		key, err := s.parseKey('=')
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		s.pos++
+		s.skipSpaces()
+		valueStart := s.pos
+		valueEnd, err := s.scanValue()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		s.skipLine()
+
+		path := append(append([]string{}, current...), key...)
+		entries = append(entries, tomlEntry{
+			path:       path,
+			start:      lineStart,
+			end:        s.pos,
+			valueStart: valueStart,
+			valueEnd:   valueEnd,
+		})
+		if tableIndex < 0 {
+			rootEnd = s.pos
+		} else {
+			tables[tableIndex].lastEnd = s.pos
+		}
+	}
+
+	return entries, tables, rootEnd, nil
+}
+
+func (s *tomlScanner) skipSpaces() {
+	for s.pos < len(s.data) && (s.data[s.pos] == ' ' || s.data[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+// skipLine 跳到下一行开头
+func (s *tomlScanner) skipLine() {
+	for s.pos < len(s.data) && s.data[s.pos] != '\n' {
+		s.pos++
+	}
+	if s.pos < len(s.data) {
+		s.pos++
+	}
+}
+
+// parseKey 解析可能带点号和引号的键，停在 end 字符上
+func (s *tomlScanner) parseKey(end byte) ([]string, error) {
+	var parts []string
+	for {
+		s.skipSpaces()
+		if s.pos >= len(s.data) {
+			return nil, errors.New("TOML 键不完整")
+		}
+
+		switch c := s.data[s.pos]; c {
+		case '"', '\'':
+			stop, err := s.scanString()
+			if err != nil {
+				return nil, err
+			}
+			var wrapper map[string]string
+			if err := toml.Unmarshal([]byte("k = "+string(s.data[s.pos:stop])), &wrapper); err != nil {
+				return nil, err
+			}
+			parts = append(parts, wrapper["k"])
+			s.pos = stop
+		default:
+			start := s.pos
+			for s.pos < len(s.data) && strings.IndexByte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_-", s.data[s.pos]) >= 0 {
+				s.pos++
+			}
+			if start == s.pos {
+				return nil, fmt.Errorf("TOML 键格式错误，位置 %d", start)
+			}
+			parts = append(parts, string(s.data[start:s.pos]))
+		}
+
+		s.skipSpaces()
+		if s.pos >= len(s.data) {
+			return nil, errors.New("TOML 键不完整")
+		}
+		if s.data[s.pos] == end {
+			return parts, nil
+		}
+		if s.data[s.pos] != '.' {
+			return nil, fmt.Errorf("TOML 键格式错误，位置 %d", s.pos)
+		}
+		s.pos++
+	}
+}
+
+// scanString 从引号处开始扫描字符串，返回结束引号之后的位置
+func (s *tomlScanner) scanString() (int, error) {
+	quote := s.data[s.pos]
+	triple := bytes.Repeat([]byte{quote}, 3)
+	if bytes.HasPrefix(s.data[s.pos:], triple) {
+		i := bytes.Index(s.data[s.pos+3:], triple)
+		for i >= 0 && quote == '"' && escaped(s.data, s.pos+3+i) {
+			next := bytes.Index(s.data[s.pos+3+i+1:], triple)
+			if next < 0 {
+				i = -1
+				break
+			}
+			i += next + 1
+		}
+		if i < 0 {
+			return 0, errors.New("TOML 多行字符串未结束")
+		}
+		end := s.pos + 3 + i + 3
+		// 结束分隔符前最多允许再出现两个引号
+		for n := 0; n < 2 && end < len(s.data) && s.data[end] == quote; n++ {
+			end++
+		}
+		return end, nil
+	}
+
+	for i := s.pos + 1; i < len(s.data); i++ {
+		switch s.data[i] {
+		case '\n':
+			return 0, errors.New("TOML 字符串未结束")
+		case quote:
+			if quote == '\'' || !escaped(s.data, i) {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, errors.New("TOML 字符串未结束")
+}
+
+// escaped 判断位置 i 的字符是否被反斜杠转义
+func escaped(data []byte, i int) bool {
+	n := 0
+	for j := i - 1; j >= 0 && data[j] == '\\'; j-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// scanValue 扫描一个值，支持跨行的数组和内联表，返回去掉尾部空白后的结束位置
+func (s *tomlScanner) scanValue() (int, error) {
+	depth := 0
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; c {
+		case '"', '\'':
+			end, err := s.scanString()
+			if err != nil {
+				return 0, err
+			}
+			s.pos = end
+			continue
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case '#':
+			if depth == 0 {
+				return s.trimmedEnd(), nil
+			}
+			for s.pos < len(s.data) && s.data[s.pos] != '\n' {
+				s.pos++
+			}
+			continue
+		case '\n':
+			if depth == 0 {
+				return s.trimmedEnd(), nil
+			}
+		}
+		s.pos++
+	}
+	return s.trimmedEnd(), nil
+}
+
+func (s *tomlScanner) trimmedEnd() int {
+	end := s.pos
+	for end > 0 && strings.IndexByte(" \t\r", s.data[end-1]) >= 0 {
+		end--
+	}
+	return end
+}
+
+func tomlPathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+func lookupOrdered(root interface{}, path []string) (interface{}, bool) {
+	value := root
+	for _, key := range path {
+		obj, ok := value.(orderedObject)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj.get(key); !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+type tomlEdit struct {
+	start, end int
+	text       string
+}
+
+// encodeTOML 在已有文件的基础上逐行修改：值变化的键原地替换，删除的键整行移除，
+// 新键追加到所在表的末尾，新表追加到文件末尾。注释和键顺序因此得以保留。
+func encodeTOML(previous, data []byte) ([]byte, error) {
+	root, err := decodeOrdered(data)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := root.(orderedObject)
+	if !ok {
+		return nil, errors.New("配置必须是对象")
+	}
+
+	entries, tables, rootEnd, err := scanTOML(previous)
+	if err != nil || len(bytes.TrimSpace(previous)) == 0 {
+		// 无法安全地按行修改时整体重写
+		if errors.Is(err, errTOMLArrayTable) {
+			log.Println("TOML 配置文件中使用了 [[表数组]]，无法保留注释，将整体重写配置文件")
+		} else if err != nil {
+			log.Println("TOML 配置文件解析失败，无法保留注释，将整体重写配置文件:", err)
+		}
+		var buf bytes.Buffer
+		writeTOMLTable(&buf, nil, obj)
+		return bytes.TrimLeft(buf.Bytes(), "\n"), nil
+	}
+
+	var edits []tomlEdit
+	covered := map[string]bool{}
+	// parents 记录文件中已经出现过的表路径（含表头和点号键隐式定义的表）
+	parents := map[string]bool{}
+
+	for _, entry := range entries {
+		for i := 1; i < len(entry.path); i++ {
+			parents[tomlPathKey(entry.path[:i])] = true
+		}
+
+		value, found := lookupOrdered(root, entry.path)
+		if !found || value == nil {
+			edits = append(edits, tomlEdit{start: entry.start, end: entry.end})
+			continue
+		}
+		covered[tomlPathKey(entry.path)] = true
+
+		text := tomlValue(value)
+		if oldValue, err := decodeTOMLValue(previous[entry.valueStart:entry.valueEnd]); err == nil && tomlValue(oldValue) == text {
+			// 语义相同则保留用户原来的写法
+			continue
+		}
+		edits = append(edits, tomlEdit{start: entry.valueStart, end: entry.valueEnd, text: text})
+	}
+
+	tableEnds := map[string]int{"": rootEnd}
+	for _, table := range tables {
+		key := tomlPathKey(table.path)
+		value, found := lookupOrdered(root, table.path)
+		if _, isObj := value.(orderedObject); !found || !isObj {
+			edits = append(edits, tomlEdit{start: table.start, end: table.end})
+			continue
+		}
+		tableEnds[key] = table.lastEnd
+		for i := 1; i <= len(table.path); i++ {
+			parents[tomlPathKey(table.path[:i])] = true
+		}
+	}
+
+	var appendix bytes.Buffer
+	var insertTables func(path []string, obj orderedObject)
+	insertTables = func(path []string, obj orderedObject) {
+		var lines []string
+		for _, entry := range obj {
+			childPath := append(append([]string{}, path...), entry.Key)
+			childKey := tomlPathKey(childPath)
+			if covered[childKey] || entry.Value == nil {
+				continue
+			}
+
+			child, isObj := entry.Value.(orderedObject)
+			if isObj && parents[childKey] {
+				insertTables(childPath, child)
+				continue
+			}
+			if isObj && len(child) > 0 {
+				writeTOMLTable(&appendix, childPath, child)
+				continue
+			}
+			lines = append(lines, tomlKey(entry.Key)+" = "+tomlValue(entry.Value)+"\n")
+		}
+		if len(lines) == 0 {
+			return
+		}
+
+		if end, ok := tableEnds[tomlPathKey(path)]; ok {
+			text := strings.Join(lines, "")
+			if end > 0 && previous[end-1] != '\n' {
+				text = "\n" + text
+			}
+			edits = append(edits, tomlEdit{start: end, end: end, text: text})
+			return
+		}
+		appendix.WriteString("\n[" + tomlKeyPath(path) + "]\n")
+		appendix.WriteString(strings.Join(lines, ""))
+	}
+	insertTables(nil, obj)
+
+	// 从后往前应用；起点相同时先删除再插入，避免插入的内容被随后的删除吞掉
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start > edits[j].start
+		}
+		return edits[i].end > edits[j].end
+	})
+	out := append([]byte{}, previous...)
+	for _, edit := range edits {
+		out = append(out[:edit.start], append([]byte(edit.text), out[edit.end:]...)...)
+	}
+
+	if appendix.Len() > 0 {
+		if len(out) > 0 && out[len(out)-1] != '\n' {
+			out = append(out, '\n')
+		}
+		out = append(out, appendix.Bytes()...)
+	}
+	return out, nil
+}
+
+// decodeTOMLValue 解析单个 TOML 值并转换为与 decodeOrdered 一致的结构
+func decodeTOMLValue(raw []byte) (interface{}, error) {
+	var wrapper map[string]interface{}
+	if err := toml.Unmarshal(append([]byte("v = "), raw...), &wrapper); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(wrapper["v"])
+	if err != nil {
+		return nil, err
+	}
+	return decodeOrdered(data)
+}
+
+// writeTOMLTable 输出一个完整的表，非空的子对象输出为子表
+func writeTOMLTable(buf *bytes.Buffer, path []string, obj orderedObject) {
+	var children []orderedEntry
+	headerWritten := false
+	for _, entry := range obj {
+		if entry.Value == nil {
+			continue
+		}
+		if child, ok := entry.Value.(orderedObject); ok && len(child) > 0 {
+			children = append(children, entry)
+			continue
+		}
+		if !headerWritten && len(path) > 0 {
+			buf.WriteString("\n[" + tomlKeyPath(path) + "]\n")
+		}
+		headerWritten = true
+		buf.WriteString(tomlKey(entry.Key) + " = " + tomlValue(entry.Value) + "\n")
+	}
+
+	for _, entry := range children {
+		writeTOMLTable(buf, append(append([]string{}, path...), entry.Key), entry.Value.(orderedObject))
+	}
+}
+
+func tomlKey(key string) string {
+	if tomlBareKey.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+func tomlKeyPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, key := range path {
+		keys[i] = tomlKey(key)
+	}
+	return strings.Join(keys, ".")
+}
+
+// tomlValue 将值编码为单行 TOML，对象使用内联表
+func tomlValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return tomlString(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if item != nil {
+				items = append(items, tomlValue(item))
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case orderedObject:
+		if len(v) == 0 {
+			return "{}"
+		}
+		items := make([]string, 0, len(v))
+		for _, entry := range v {
+			if entry.Value != nil {
+				items = append(items, tomlKey(entry.Key)+" = "+tomlValue(entry.Value))
+			}
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	}
+	return `""`
+}
+
+// tomlString 编码为 TOML 基本字符串
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -2,42 +2,204 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
+// ServerState 服务器生命周期状态
+type ServerState string
+
+const (
+	StateStopped  ServerState = "stopped"
+	StateStarting ServerState = "starting"
+	StateRunning  ServerState = "running"
+	StateStopping ServerState = "stopping"
+	StateFailed   ServerState = "failed"
+)
+
+// ServerStatus 供前端查询的服务器状态
+type ServerStatus struct {
+	State     ServerState `json:"state"`
+	Addr      string      `json:"addr"`
+	Uptime    int64       `json:"uptime"` // 单位：秒
+	LastError string      `json:"last_error"`
+	// VaultLocked 因密钥库口令错误而启动失败，前端据此提示解锁
+	VaultLocked bool `json:"vault_locked"`
+}
+
+// configWatchInterval 配置文件轮询间隔
+const configWatchInterval = 2 * time.Second
+
+// listen 绑定监听端口，测试中替换以模拟监听异常
+var listen = net.Listen
+
+// routerHandler 允许在不重启监听的情况下替换路由
+type routerHandler struct {
+	engine atomic.Pointer[gin.Engine]
+}
+
+func (h *routerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.engine.Load().ServeHTTP(w, r)
+}
+
 type Manager struct {
-	server *http.Server
-	config config
+	mu        sync.Mutex
+	reloadMu  sync.Mutex
+	state     ServerState
+	server    *http.Server
+	proxy     *ProxyService
+	handler   *routerHandler
+	watcher   *configWatcher
+	config    config
+	addr      string
+	startedAt time.Time
+	lastErr   error
+	// onBreakerChange 上游熔断状态变化时调用
+	onBreakerChange func(BreakerStatus)
 }
 
 func NewServerManager() *Manager {
-	return &Manager{}
+	return &Manager{state: StateStopped}
 }
 
 func (sm *Manager) Start() ResponseData {
+	if resp, ok := sm.begin(); !ok {
+		return resp
+	}
+
 	// 读取配置
-	respData := ReadConfig()
-	if respData.Status == "fail" {
-		return ResponseData{
-			Status: "fail",
-			Msg:    "读取配置失败，请先创建配置文件",
+	cfg, err := loadConfig()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return sm.fail(err, ResponseData{
+				Status: "fail",
+				Msg:    "读取配置失败，请先创建配置文件",
+			})
+		}
+		if errors.Is(err, ErrVaultLocked) {
+			return sm.fail(err, ResponseData{
+				Status: "fail",
+				Msg:    "密钥库已锁定，请先输入口令解锁",
+			})
 		}
+		return sm.fail(err, ResponseData{
+			Status: "fail",
+			Msg:    "配置解析失败: " + err.Error(),
+		})
 	}
+	if errs := validateConfig(&cfg); len(errs) > 0 {
+		return sm.fail(errs[0], ResponseData{
+			Status: "fail",
+			Msg:    "配置校验失败: " + errs[0].Error(),
+		})
+	}
+
+	return sm.launch(cfg)
+}
 
-	// 类型断言配置数据
-	cfg, ok := respData.Data.(config)
-	if !ok {
+// begin 检查当前状态是否允许启动，并切换到 starting
+func (sm *Manager) begin() (ResponseData, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	switch sm.state {
+	case StateStarting, StateRunning:
 		return ResponseData{
 			Status: "fail",
-			Msg:    "配置解析失败",
-		}
+			Msg:    "服务器已在运行中",
+		}, false
+	case StateStopping:
+		return ResponseData{
+			Status: "fail",
+			Msg:    "服务器正在停止，请稍后再试",
+		}, false
+	}
+	sm.state = StateStarting
+	sm.lastErr = nil
+	return ResponseData{}, true
+}
+
+// launch 使用给定配置绑定端口并启动服务器，调用前必须已处于 starting 状态
+func (sm *Manager) launch(cfg config) ResponseData {
+	// 初始化 Proxy 服务
+	proxyService, err := NewProxyService(&cfg)
+	if err != nil {
+		return sm.fail(err, ResponseData{
+			Status: "fail",
+			Msg:    "初始化 Proxy 服务失败: " + err.Error(),
+		})
+	}
+	sm.mu.Lock()
+	proxyService.breakers.setListener(sm.onBreakerChange)
+	sm.mu.Unlock()
+	handler := &routerHandler{}
+	handler.engine.Store(newRouter(proxyService))
+
+	// 同步绑定端口，端口冲突等错误可以直接返回给调用方
+	listener, err := listen("tcp", cfg.Bind)
+	if err != nil {
+		return sm.fail(err, ResponseData{
+			Status: "fail",
+			Msg:    "服务器启动失败: " + err.Error(),
+		})
 	}
+
+	// 创建 HTTP 服务器
+	server := &http.Server{
+		Addr:    cfg.Bind,
+		Handler: handler,
+	}
+
+	sm.mu.Lock()
 	sm.config = cfg
+	sm.server = server
+	sm.proxy = proxyService
+	sm.handler = handler
+	sm.addr = listener.Addr().String()
+	sm.startedAt = time.Now()
+	sm.state = StateRunning
+	// 从 failed 状态重新启动时，上一次的配置文件监听可能还没有关闭
+	sm.closeWatcher()
+	sm.watcher = watchConfig(ConfigPath(), configWatchInterval, sm.reloadFromDisk)
+	sm.mu.Unlock()
+
+	// 启动服务器的 Goroutine
+	go func() {
+		err := server.Serve(listener)
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			// 正常关闭不视为错误
+			return
+		}
+
+		log.Println("服务器异常退出:", err)
+		sm.mu.Lock()
+		if sm.server == server {
+			sm.state = StateFailed
+			sm.lastErr = err
+			sm.closeWatcher()
+		}
+		sm.mu.Unlock()
+	}()
+
+	return ResponseData{
+		Status: "success",
+		Data:   sm.Status(),
+		Msg:    "服务器已成功启动",
+	}
+}
 
+// newRouter 创建挂载了 Proxy 路由的 Gin 引擎
+func newRouter(proxyService *ProxyService) *gin.Engine {
 	// 设置 Gin 为发布模式
 	gin.SetMode(gin.ReleaseMode)
 
@@ -50,63 +212,263 @@ func (sm *Manager) Start() ResponseData {
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
+	proxyService.InitRoutes(router)
 
-	// 初始化 Proxy 服务
-	proxyService, err := NewProxyService(&cfg)
-	if err != nil {
+	return router
+}
+
+// Reload 将新配置应用到运行中的服务器，只有 bind 变化时才会重启监听
+func (sm *Manager) Reload(cfg config) ResponseData {
+	sm.reloadMu.Lock()
+	defer sm.reloadMu.Unlock()
+
+	sm.mu.Lock()
+	if sm.state != StateRunning {
+		sm.mu.Unlock()
 		return ResponseData{
-			Status: "fail",
-			Msg:    "初始化 Proxy 服务失败: " + err.Error(),
+			Status: "success",
+			Msg:    "服务器未运行，配置将在下次启动时生效",
 		}
 	}
-	proxyService.InitRoutes(router)
+	current := sm.config
+	proxyService := sm.proxy
+	handler := sm.handler
+	sm.mu.Unlock()
 
-	// 创建 HTTP 服务器
-	sm.server = &http.Server{
-		Addr:    cfg.Bind,
-		Handler: router,
+	if reflect.DeepEqual(current, cfg) {
+		return ResponseData{
+			Status: "success",
+			Data:   sm.Status(),
+			Msg:    "配置未变化",
+		}
 	}
 
-	// 创建一个传递错误的通道
-	errChan := make(chan error)
+	if current.Bind != cfg.Bind {
+		log.Println("监听地址变化，重启服务器:", current.Bind, "->", cfg.Bind)
+		if resp := sm.Stop(); resp.Status == "fail" {
+			return resp
+		}
+		if resp, ok := sm.begin(); !ok {
+			return resp
+		}
+		return sm.launch(cfg)
+	}
 
-	// 启动服务器的 Goroutine
-	go func() {
-		err := sm.server.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
-			// 如果启动有错误，发送到通道
-			errChan <- err
-		} else {
-			// 正常关闭不视为错误
-			errChan <- nil
+	if err := proxyService.UpdateConfig(&cfg); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置热更新失败: " + err.Error(),
 		}
-		close(errChan)
-	}()
+	}
+	if current.AuthToken != cfg.AuthToken {
+		// 鉴权路由在注册时确定，需要整体替换路由
+		handler.engine.Store(newRouter(proxyService))
+	}
+
+	sm.mu.Lock()
+	sm.config = cfg
+	sm.mu.Unlock()
 
-	// 这里假设服务器能正常启动
+	log.Println("配置已热更新")
 	return ResponseData{
 		Status: "success",
-		Msg:    "服务器已成功启动",
+		Data:   sm.Status(),
+		Msg:    "配置已热更新",
+	}
+}
+
+// UpdateConfig 保存配置并热更新到运行中的服务器
+func (sm *Manager) UpdateConfig(configData string) ResponseData {
+	respData := UpdateConfig(configData)
+	if respData.Status == "fail" {
+		return respData
+	}
+
+	if resp := sm.applyConfigFile(); resp.Status == "fail" {
+		return resp
+	}
+	return respData
+}
+
+// ActivateProfile 切换 profile 并立即应用到运行中的服务器
+func (sm *Manager) ActivateProfile(name string) ResponseData {
+	respData := ActivateProfile(name)
+	if respData.Status == "fail" {
+		return respData
+	}
+
+	if resp := sm.applyConfigFile(); resp.Status == "fail" {
+		return resp
+	}
+	return respData
+}
+
+// ImportConfig 导入配置并立即应用到运行中的服务器
+func (sm *Manager) ImportConfig(data, mode string) ResponseData {
+	respData := ImportConfig(data, mode)
+	if respData.Status == "fail" {
+		return respData
+	}
+
+	if resp := sm.applyConfigFile(); resp.Status == "fail" {
+		return resp
+	}
+	return respData
+}
+
+// ConvertConfig 转换配置文件格式，运行中的服务器改为监听新的配置文件
+func (sm *Manager) ConvertConfig(format string) ResponseData {
+	respData := ConvertConfig(format)
+	if respData.Status == "fail" {
+		return respData
+	}
+
+	sm.mu.Lock()
+	if sm.watcher != nil {
+		sm.watcher.Close()
+		sm.watcher = watchConfig(ConfigPath(), configWatchInterval, sm.reloadFromDisk)
+	}
+	sm.mu.Unlock()
+	return respData
+}
+
+// reloadFromDisk 在配置文件被外部修改后重新加载
+func (sm *Manager) reloadFromDisk() {
+	if resp := sm.applyConfigFile(); resp.Status == "fail" {
+		log.Println(resp.Msg)
+	}
+}
+
+// applyConfigFile 读取磁盘上的配置并热更新到运行中的服务器
+func (sm *Manager) applyConfigFile() ResponseData {
+	cfg, err := loadConfig()
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "重新加载配置失败: " + err.Error(),
+		}
+	}
+	if errs := validateConfig(&cfg); len(errs) > 0 {
+		return ResponseData{
+			Status: "fail",
+			Data:   errs,
+			Msg:    "配置校验失败，未应用到服务器: " + errs[0].Error(),
+		}
+	}
+
+	return sm.Reload(cfg)
+}
+
+// fail 记录启动失败的原因并返回对应的响应
+func (sm *Manager) fail(err error, resp ResponseData) ResponseData {
+	sm.mu.Lock()
+	sm.state = StateFailed
+	sm.lastErr = err
+	sm.mu.Unlock()
+
+	resp.Data = sm.Status()
+	return resp
+}
+
+// closeWatcher 停止监听配置文件，调用方需持有 sm.mu
+func (sm *Manager) closeWatcher() {
+	if sm.watcher != nil {
+		sm.watcher.Close()
+		sm.watcher = nil
 	}
 }
 
 func (sm *Manager) Stop() ResponseData {
+	sm.mu.Lock()
+	switch sm.state {
+	case StateStopped:
+		sm.mu.Unlock()
+		return ResponseData{
+			Status: "fail",
+			Msg:    "服务器未运行",
+		}
+	case StateStarting, StateStopping:
+		sm.mu.Unlock()
+		return ResponseData{
+			Status: "fail",
+			Msg:    "服务器状态切换中，请稍后再试",
+		}
+	}
+	server := sm.server
+	sm.closeWatcher()
+	sm.state = StateStopping
+	sm.mu.Unlock()
+
 	// 使用 context.Background() 立即停止服务器
 	log.Println("正在尝试立即停止服务器...")
 
 	// 停止服务器
-	err := sm.server.Shutdown(context.Background())
+	var err error
+	if server != nil {
+		err = server.Shutdown(context.Background())
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 	if err != nil {
 		log.Println("停止服务器时出错:", err)
+		sm.state = StateFailed
+		sm.lastErr = err
 		return ResponseData{
 			Status: "fail",
 			Msg:    "服务器停止失败: " + err.Error(),
 		}
 	}
 
+	sm.state = StateStopped
+	sm.server = nil
+	sm.proxy = nil
+	sm.handler = nil
+	sm.addr = ""
 	log.Println("服务器已成功停止。")
 	return ResponseData{
 		Status: "success",
 		Msg:    "服务器已成功停止",
 	}
 }
+
+// Status 返回当前服务器状态快照
+func (sm *Manager) Status() ServerStatus {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	status := ServerStatus{
+		State: sm.state,
+		Addr:  sm.addr,
+	}
+	if sm.state == StateRunning {
+		status.Uptime = int64(time.Since(sm.startedAt).Seconds())
+	}
+	if sm.lastErr != nil {
+		status.LastError = sm.lastErr.Error()
+		status.VaultLocked = errors.Is(sm.lastErr, ErrVaultLocked)
+	}
+	return status
+}
+
+// SetBreakerListener 设置上游熔断状态变化的回调，回调在独立的 goroutine 中执行
+func (sm *Manager) SetBreakerListener(fn func(BreakerStatus)) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.onBreakerChange = fn
+	if sm.proxy != nil {
+		sm.proxy.breakers.setListener(fn)
+	}
+}
+
+// UpstreamStatus 返回服务器运行期间各上游的熔断状态
+func (sm *Manager) UpstreamStatus() []BreakerStatus {
+	sm.mu.Lock()
+	proxy := sm.proxy
+	sm.mu.Unlock()
+
+	if proxy == nil {
+		return []BreakerStatus{}
+	}
+	return proxy.breakers.statuses()
+}
@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useServerConfig 写入监听 bind 的完整配置
+func useServerConfig(t *testing.T, bind string) {
+	t.Helper()
+	useVault(t, `{}`)
+	cfg := defaultConfig()
+	cfg.Bind = bind
+	cfg.ChatApiKey, cfg.CodexApiKey = "sk-test", "sk-test"
+	doc := newConfigFile()
+	doc.Profiles[defaultProfileName] = cfg
+	content, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ConfigPath(), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startManager 启动服务器，测试结束时停止
+func startManager(t *testing.T) *Manager {
+	t.Helper()
+	sm := NewServerManager()
+	if resp := sm.Start(); resp.Status != "success" {
+		t.Fatalf("Start = %+v", resp)
+	}
+	t.Cleanup(func() { sm.Stop() })
+	return sm
+}
+
+func TestStartBindConflict(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+	useServerConfig(t, occupied.Addr().String())
+
+	sm := NewServerManager()
+	resp := sm.Start()
+	if resp.Status != "fail" || !strings.Contains(resp.Msg, "服务器启动失败") {
+		t.Fatalf("Start = %+v", resp)
+	}
+	status, ok := resp.Data.(ServerStatus)
+	if !ok || status.State != StateFailed || status.LastError == "" {
+		t.Errorf("Start 返回的状态 = %+v", resp.Data)
+	}
+	if status := sm.Status(); status.State != StateFailed || status.LastError == "" || status.Addr != "" {
+		t.Errorf("Status = %+v", status)
+	}
+	if sm.watcher != nil {
+		t.Error("启动失败时不应监听配置文件")
+	}
+}
+
+func TestStartWhileRunning(t *testing.T) {
+	useServerConfig(t, "127.0.0.1:0")
+	sm := startManager(t)
+
+	resp := sm.Start()
+	if resp.Status != "fail" || resp.Msg != "服务器已在运行中" {
+		t.Errorf("第二次 Start = %+v", resp)
+	}
+	if status := sm.Status(); status.State != StateRunning || status.LastError != "" {
+		t.Errorf("Status = %+v", status)
+	}
+}
+
+func TestStopThenStart(t *testing.T) {
+	useServerConfig(t, "127.0.0.1:0")
+	sm := startManager(t)
+	first := sm.Status().Addr
+
+	if resp := sm.Stop(); resp.Status != "success" {
+		t.Fatalf("Stop = %+v", resp)
+	}
+	if status := sm.Status(); status.State != StateStopped || status.Addr != "" || status.Uptime != 0 {
+		t.Errorf("停止后 Status = %+v", status)
+	}
+	if _, err := net.Dial("tcp", first); err == nil {
+		t.Errorf("停止后 %s 仍在监听", first)
+	}
+	if resp := sm.Stop(); resp.Status != "fail" {
+		t.Errorf("重复 Stop = %+v", resp)
+	}
+
+	if resp := sm.Start(); resp.Status != "success" {
+		t.Fatalf("再次 Start = %+v", resp)
+	}
+	if status := sm.Status(); status.State != StateRunning {
+		t.Errorf("再次启动后 Status = %+v", status)
+	}
+}
+
+func TestStatusReportsAddrAndUptime(t *testing.T) {
+	useServerConfig(t, "127.0.0.1:0")
+	sm := startManager(t)
+
+	status := sm.Status()
+	if status.State != StateRunning || status.Uptime != 0 {
+		t.Errorf("Status = %+v", status)
+	}
+	// bind 使用 0 端口时返回实际监听的地址
+	host, port, err := net.SplitHostPort(status.Addr)
+	if err != nil || host != "127.0.0.1" || port == "0" {
+		t.Fatalf("addr = %q", status.Addr)
+	}
+	conn, err := net.Dial("tcp", status.Addr)
+	if err != nil {
+		t.Fatalf("无法连接 %s: %v", status.Addr, err)
+	}
+	conn.Close()
+
+	sm.mu.Lock()
+	sm.startedAt = sm.startedAt.Add(-90 * time.Second)
+	sm.mu.Unlock()
+	if uptime := sm.Status().Uptime; uptime < 90 || uptime > 91 {
+		t.Errorf("uptime = %d，期望 90", uptime)
+	}
+}
+
+// failingListener Accept 在 fail 关闭后返回错误，模拟监听异常退出
+type failingListener struct {
+	net.Listener
+	fail chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	<-l.fail
+	return nil, errors.New("accept failed")
+}
+
+func TestServeFailureClosesWatcher(t *testing.T) {
+	useServerConfig(t, "127.0.0.1:0")
+	var listeners []*failingListener
+	listen = func(network, addr string) (net.Listener, error) {
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		fl := &failingListener{Listener: ln, fail: make(chan struct{})}
+		listeners = append(listeners, fl)
+		return fl, nil
+	}
+	t.Cleanup(func() {
+		listen = net.Listen
+		for _, fl := range listeners {
+			fl.Listener.Close()
+		}
+	})
+
+	sm := startManager(t)
+	watcher := sm.watcher
+	close(listeners[0].fail)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for sm.Status().State != StateFailed {
+		if time.Now().After(deadline) {
+			t.Fatalf("Serve 退出后 Status = %+v", sm.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := sm.Status(); !strings.Contains(status.LastError, "accept failed") {
+		t.Errorf("last_error = %q", status.LastError)
+	}
+	select {
+	case <-watcher.stop:
+	default:
+		t.Error("Serve 异常退出后配置文件监听没有关闭")
+	}
+
+	// failed 状态下可以重新启动，只保留新的监听
+	if resp := sm.Start(); resp.Status != "success" {
+		t.Fatalf("重新启动 = %+v", resp)
+	}
+	sm.mu.Lock()
+	current := sm.watcher
+	sm.mu.Unlock()
+	if current == nil || current == watcher {
+		t.Errorf("重新启动后的监听 = %p，旧监听 %p", current, watcher)
+	}
+}
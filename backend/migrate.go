@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// currentConfigVersion 当前配置文件版本，每新增一个迁移步骤加一
+const currentConfigVersion = 2
+
+// migration 将配置升级一个版本，输入输出均为配置文件原始 JSON
+type migration func(data []byte) ([]byte, error)
+
+// migrations[i] 将版本 i 的配置升级到版本 i+1，只能追加不能修改已发布的步骤
+var migrations = []migration{
+	migrateV0ToV1,
+	migrateV1ToV2,
+}
+
+// migrateConfig 逐步升级配置到当前版本，返回升级后的内容和原始版本号
+func migrateConfig(data []byte) ([]byte, int, error) {
+	version := int(gjson.GetBytes(data, "version").Int())
+	if version > currentConfigVersion {
+		return nil, version, fmt.Errorf("配置文件版本 %d 高于当前程序支持的版本 %d", version, currentConfigVersion)
+	}
+
+	for v := version; v < currentConfigVersion; v++ {
+		next, err := migrations[v](data)
+		if err != nil {
+			return nil, version, fmt.Errorf("配置从版本 %d 升级失败: %w", v, err)
+		}
+		data, err = sjson.SetBytes(next, "version", v+1)
+		if err != nil {
+			return nil, version, err
+		}
+	}
+
+	return data, version, nil
+}
+
+// migrateV0ToV1 无版本号的扁平配置：去掉 api base 末尾的斜杠，避免拼接出 "//chat/completions"
+func migrateV0ToV1(data []byte) ([]byte, error) {
+	var err error
+	for _, key := range []string{"codex_api_base", "chat_api_base"} {
+		base := gjson.GetBytes(data, key)
+		if base.Type != gjson.String {
+			continue
+		}
+
+		data, err = sjson.SetBytes(data, key, strings.TrimRight(base.String(), "/"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// migrateV1ToV2 扁平配置整体移入名为 default 的 profile
+func migrateV1ToV2(data []byte) ([]byte, error) {
+	profile, err := sjson.DeleteBytes(data, "version")
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := sjson.SetBytes([]byte(`{}`), "active_profile", defaultProfileName)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetRawBytes(out, "profiles."+defaultProfileName, profile)
+}
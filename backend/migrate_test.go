@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useConfigFile 在临时目录写入配置文件并通过 SetConfigPath 使用它，测试结束时恢复
+func useConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetConfigPath(path)
+	t.Cleanup(func() { SetConfigPath("") })
+	return path
+}
+
+// assertJSONEqual 忽略键顺序和空白比较两段 JSON
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("无效的 JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("无效的期望 JSON %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("JSON 不一致\n实际: %s\n期望: %s", got, want)
+	}
+}
+
+func TestMigrationSteps(t *testing.T) {
+	tests := []struct {
+		name string
+		step migration
+		in   string
+		want string
+	}{
+		{
+			name: "v0->v1 去掉 api base 末尾斜杠",
+			step: migrateV0ToV1,
+			in:   `{"codex_api_base":"https://api.deepseek.com/beta/v1/","chat_api_base":"https://api.deepseek.com/v1//","chat_api_key":"sk-1"}`,
+			want: `{"codex_api_base":"https://api.deepseek.com/beta/v1","chat_api_base":"https://api.deepseek.com/v1","chat_api_key":"sk-1"}`,
+		},
+		{
+			name: "v0->v1 没有斜杠时保持不变",
+			step: migrateV0ToV1,
+			in:   `{"codex_api_base":"https://api.deepseek.com/beta/v1","chat_max_tokens":4096}`,
+			want: `{"codex_api_base":"https://api.deepseek.com/beta/v1","chat_max_tokens":4096}`,
+		},
+		{
+			name: "v0->v1 缺少字段或类型不是字符串时跳过",
+			step: migrateV0ToV1,
+			in:   `{"chat_api_base":null,"bind":"127.0.0.1:8181"}`,
+			want: `{"chat_api_base":null,"bind":"127.0.0.1:8181"}`,
+		},
+		{
+			name: "v1->v2 扁平配置移入 default profile",
+			step: migrateV1ToV2,
+			in:   `{"version":1,"bind":"127.0.0.1:8181","chat_model_map":{"gpt-4":"deepseek-chat"}}`,
+			want: `{"active_profile":"default","profiles":{"default":{"bind":"127.0.0.1:8181","chat_model_map":{"gpt-4":"deepseek-chat"}}}}`,
+		},
+		{
+			name: "v1->v2 空配置",
+			step: migrateV1ToV2,
+			in:   `{"version":1}`,
+			want: `{"active_profile":"default","profiles":{"default":{}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.step([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		want        string
+		wantVersion int
+	}{
+		{
+			name:        "从 v0 升级到当前版本",
+			in:          `{"chat_api_base":"https://api.deepseek.com/v1/"}`,
+			want:        `{"version":2,"active_profile":"default","profiles":{"default":{"chat_api_base":"https://api.deepseek.com/v1"}}}`,
+			wantVersion: 0,
+		},
+		{
+			name:        "从 v1 升级时不再处理斜杠",
+			in:          `{"version":1,"chat_api_base":"https://api.deepseek.com/v1/"}`,
+			want:        `{"version":2,"active_profile":"default","profiles":{"default":{"chat_api_base":"https://api.deepseek.com/v1/"}}}`,
+			wantVersion: 1,
+		},
+		{
+			name:        "当前版本原样返回",
+			in:          `{"version":2,"active_profile":"work","profiles":{"work":{}}}`,
+			want:        `{"version":2,"active_profile":"work","profiles":{"work":{}}}`,
+			wantVersion: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, version, err := migrateConfig([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("原始版本 = %d，期望 %d", version, tt.wantVersion)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestMigrateConfigRejectsNewerVersion(t *testing.T) {
+	_, version, err := migrateConfig([]byte(`{"version":99}`))
+	if err == nil || !strings.Contains(err.Error(), "高于当前程序支持的版本") {
+		t.Fatalf("期望版本过高的错误，实际 %v", err)
+	}
+	if version != 99 {
+		t.Errorf("原始版本 = %d，期望 99", version)
+	}
+}
+
+func TestLoadConfigFileWritesBackup(t *testing.T) {
+	original := `{"chat_api_base":"https://api.deepseek.com/v1/","chat_max_tokens":2048}`
+	path := useConfigFile(t, "config.json", original)
+
+	data, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"version":2,"active_profile":"default","profiles":{"default":{"chat_api_base":"https://api.deepseek.com/v1","chat_max_tokens":2048}}}`
+	assertJSONEqual(t, data, want)
+
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil {
+		t.Fatal("升级前应写入 .bak 备份:", err)
+	}
+	if string(backup) != original {
+		t.Errorf(".bak 内容 = %s，期望原始文件 %s", backup, original)
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSONEqual(t, written, want)
+
+	// 已是当前版本时不再备份
+	if err := os.Remove(path + ".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Error("当前版本的配置不应再次备份")
+	}
+}
+
+func TestLoadConfigFileRejectsNewerVersion(t *testing.T) {
+	original := `{"version":99,"active_profile":"default","profiles":{}}`
+	path := useConfigFile(t, "config.json", original)
+
+	if _, err := loadConfigFile(); err == nil {
+		t.Fatal("版本高于当前程序时应返回错误")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != original {
+		t.Error("版本过高时不应修改配置文件")
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Error("版本过高时不应写入 .bak")
+	}
+}
@@ -0,0 +1,268 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// modelsCacheTTL 上游模型列表的缓存时间
+const modelsCacheTTL = 5 * time.Minute
+
+// modelsDiscoveryTimeout 获取上游模型列表的超时时间，避免 /models 被慢速上游拖住
+const modelsDiscoveryTimeout = 10 * time.Second
+
+// 模型能力类型，对应 capabilities.type
+const (
+	modelTypeChat       = "chat"
+	modelTypeCompletion = "completion"
+	modelTypeEmbeddings = "embeddings"
+)
+
+type modelCacheEntry struct {
+	names   []string
+	expires time.Time
+}
+
+// modelCache 按上游缓存模型列表，获取失败时沿用过期的结果
+type modelCache struct {
+	mu      sync.Mutex
+	entries map[string]modelCacheEntry
+}
+
+func (c *modelCache) get(key string) (modelCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *modelCache) set(key string, names []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]modelCacheEntry{}
+	}
+	c.entries[key] = modelCacheEntry{names: names, expires: time.Now().Add(modelsCacheTTL)}
+}
+
+// modelCacheKey 同一地址下不同 key 的模型列表相同，只按地址缓存和请求一次
+func modelCacheKey(up Upstream) string {
+	provider := up.Provider
+	if provider == "" {
+		provider = DefaultProviderName
+	}
+	return strings.Join([]string{provider, up.Base, up.APIVersion}, "\x00")
+}
+
+// has 上游返回过的模型列表（含已过期的）中是否有该模型，只查缓存不请求上游
+func (c *modelCache) has(up Upstream, model string) bool {
+	entry, ok := c.get(modelCacheKey(up))
+	if !ok {
+		return false
+	}
+	for _, name := range entry.names {
+		if name == model {
+			return true
+		}
+	}
+	return false
+}
+
+// list 返回上游的模型列表，Provider 未实现 ModelLister 时返回空
+func (c *modelCache) list(ctx context.Context, client *http.Client, up Upstream) ([]string, error) {
+	p, err := providerFor(up.Provider)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := p.(ModelLister)
+	if !ok {
+		return nil, nil
+	}
+
+	key := modelCacheKey(up)
+	entry, cached := c.get(key)
+	if cached && time.Now().Before(entry.expires) {
+		return entry.names, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, modelsDiscoveryTimeout)
+	defer cancel()
+	names, err := lister.ListModels(ctx, client, up)
+	if err != nil {
+		if cached {
+			log.Println("获取上游模型列表失败，使用缓存:", err)
+			return entry.names, nil
+		}
+		return nil, err
+	}
+	c.set(key, names)
+	return names, nil
+}
+
+// modelType 根据模型名推断能力类型，上游返回的列表中不区分类型
+func modelType(name, fallback string) string {
+	if strings.Contains(strings.ToLower(name), "embed") {
+		return modelTypeEmbeddings
+	}
+	return fallback
+}
+
+// modelEntry 转换为 Copilot 使用的模型格式
+func modelEntry(name, typ string) gin.H {
+	return gin.H{
+		"capabilities": gin.H{
+			"family": name,
+			"object": "model_capabilities",
+			"type":   typ,
+		},
+		"id":      name,
+		"name":    name,
+		"object":  "model",
+		"version": name,
+	}
+}
+
+// availableModels 汇总配置中的模型和各上游返回的模型，同名只保留第一次出现的
+func (s *ProxyService) availableModels(ctx context.Context, snapshot *proxySnapshot) []gin.H {
+	cfg := snapshot.cfg
+	entries := []gin.H{}
+	seen := map[string]bool{}
+	add := func(name, typ string) {
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		entries = append(entries, modelEntry(name, typ))
+	}
+
+	add(cfg.ChatModelDefault, modelTypeChat)
+	for _, key := range sortedKeys(cfg.ChatModelMap) {
+		add(key, modelType(key, modelTypeChat))
+	}
+	add(cfg.CodeInstructModel, modelTypeCompletion)
+	add(cfg.EmbeddingsModelDefault, modelTypeEmbeddings)
+	for _, key := range sortedKeys(cfg.EmbeddingsModelMap) {
+		add(key, modelTypeEmbeddings)
+	}
+
+	// 各上游并发获取，同一地址只请求一次，/models 最多等待一个超时时间；结果仍按配置顺序合并
+	type discovery struct {
+		up    Upstream
+		typ   string
+		names []string
+	}
+	var discoveries []*discovery
+	seenUpstream := map[string]bool{}
+	type route struct {
+		ups []Upstream
+		typ string
+	}
+	routes := []route{
+		{cfg.chatUpstreams(), modelTypeChat},
+		{cfg.codexUpstreams(), modelTypeCompletion},
+	}
+	// 单独配置的 embeddings 上游放在最后，与 chat、codex 上游同名的模型保留前面的类型；
+	// 未单独配置时使用 chat 上游，已在上面获取
+	if cfg.EmbeddingsApiBase != "" {
+		routes = append(routes, route{[]Upstream{cfg.embeddingsUpstream()}, modelTypeEmbeddings})
+	}
+	for _, route := range routes {
+		for _, up := range route.ups {
+			if key := modelCacheKey(up); !seenUpstream[key] {
+				seenUpstream[key] = true
+				discoveries = append(discoveries, &discovery{up: up, typ: route.typ})
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range discoveries {
+		wg.Add(1)
+		go func(d *discovery) {
+			defer wg.Done()
+			names, err := s.modelCache.list(ctx, snapshot.client, d.up)
+			if err != nil {
+				log.Println("获取上游模型列表失败:", d.up.label(), err)
+				return
+			}
+			d.names = append([]string{}, names...)
+			sort.Strings(d.names)
+		}(d)
+	}
+	wg.Wait()
+
+	for _, d := range discoveries {
+		for _, name := range d.names {
+			add(name, modelType(name, d.typ))
+		}
+	}
+	return entries
+}
+
+// knownModel 模型是否为上游可以直接处理的模型：出现在上游的 model_map 或上游返回过的模型列表中。
+// 只查缓存，不会因此请求上游。
+func (s *ProxyService) knownModel(ups []Upstream, model string) bool {
+	if model == "" {
+		return false
+	}
+	for _, up := range ups {
+		if _, ok := up.ModelMap[model]; ok {
+			return true
+		}
+		if s.modelCache.has(up, model) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fetchJSON 发送请求并读取响应体，非 200 时返回包含响应内容的错误
+func fetchJSON(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeIO(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("上游返回 %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// listOpenAIModels 读取 OpenAI 格式的模型列表 {"data": [{"id": "..."}]}
+func listOpenAIModels(client *http.Client, req *http.Request) ([]string, error) {
+	body, err := fetchJSON(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, model := range gjson.GetBytes(body, "data").Array() {
+		names = append(names, model.Get("id").String())
+	}
+	return names, nil
+}
@@ -0,0 +1,146 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// modelsUpstream 返回固定模型列表，并记录 chat 请求中的模型名
+func modelsUpstream(t *testing.T, models string, gotModel *string) string {
+	return newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/models" {
+			_, _ = io.WriteString(w, models)
+			return
+		}
+		*gotModel = gjson.GetBytes(body, "model").String()
+		_, _ = io.WriteString(w, `{"choices":[]}`)
+	}).URL
+}
+
+func chatModel(t *testing.T, s *ProxyService, model string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"`+model+`","messages":[{"role":"user","content":"hi"}]}`))
+	s.completions(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("chat 返回 %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCompletionsPassesDiscoveredModels(t *testing.T) {
+	var gotModel string
+	upstream := modelsUpstream(t, `{"data":[{"id":"deepseek-chat"},{"id":"deepseek-reasoner"}]}`, &gotModel)
+	s := newTestService(t, upstream, func(cfg *config) {
+		cfg.ChatModelMap = map[string]string{"gpt-4": "deepseek-chat"}
+		cfg.ChatUpstreams = []UpstreamConfig{{ApiBase: upstream + "/backup", ModelMap: map[string]string{"claude-mapped": "x"}}}
+	})
+
+	// 还没有获取过模型列表时，未配置的模型使用默认模型
+	chatModel(t, s, "deepseek-reasoner")
+	if gotModel != "deepseek-chat" {
+		t.Errorf("未知模型应使用默认模型，实际 %s", gotModel)
+	}
+
+	s.availableModels(context.Background(), s.current())
+
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"deepseek-reasoner", "deepseek-reasoner"},
+		{"gpt-4", "deepseek-chat"},
+		{"gpt-4o", "deepseek-chat"},
+		{"claude-mapped", "claude-mapped"},
+		{"", "deepseek-chat"},
+	}
+	for _, tt := range tests {
+		chatModel(t, s, tt.model)
+		if gotModel != tt.want {
+			t.Errorf("请求模型 %q，上游收到 %q，期望 %q", tt.model, gotModel, tt.want)
+		}
+	}
+}
+
+func TestAvailableModelsDiscoversConcurrently(t *testing.T) {
+	var hits [2]atomic.Int32
+	slow := func(i int, models string) string {
+		return newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			time.Sleep(300 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, models)
+		}).URL
+	}
+	first := slow(0, `{"data":[{"id":"model-b"},{"id":"model-a"}]}`)
+	second := slow(1, `{"data":[{"id":"model-c"}]}`)
+
+	s := newTestService(t, first, func(cfg *config) {
+		cfg.ChatApiKeys = []string{"sk-2", "sk-3"}
+		cfg.ChatUpstreams = []UpstreamConfig{{ApiBase: second, ApiKeys: []string{"sk-4", "sk-5"}}}
+	})
+
+	start := time.Now()
+	entries := s.availableModels(context.Background(), s.current())
+	if elapsed := time.Since(start); elapsed > 550*time.Millisecond {
+		t.Errorf("两个上游串行获取，耗时 %s", elapsed)
+	}
+	for i := range hits {
+		if n := hits[i].Load(); n != 1 {
+			t.Errorf("上游 %d 被请求 %d 次，同一地址的多个 key 应只请求一次", i, n)
+		}
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry["id"].(string))
+	}
+	if got, want := strings.Join(ids, ","), "deepseek-chat,deepseek-coder,model-a,model-b,model-c"; got != want {
+		t.Errorf("模型列表 = %s，期望 %s", got, want)
+	}
+
+	// 缓存命中时不再请求上游
+	s.availableModels(context.Background(), s.current())
+	if hits[0].Load() != 1 || hits[1].Load() != 1 {
+		t.Error("缓存有效期内不应再次请求上游")
+	}
+}
+
+func TestAvailableModelsIncludesEmbeddingsUpstream(t *testing.T) {
+	var gotModel string
+	chat := modelsUpstream(t, `{"data":[{"id":"gpt-4o"}]}`, &gotModel)
+	var gotKey atomic.Value
+	embeddings := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		gotKey.Store(r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"data":[{"id":"bge-m3"},{"id":"gpt-4o"}]}`)
+	}).URL
+	s := newTestService(t, chat, func(cfg *config) {
+		cfg.EmbeddingsApiBase = embeddings
+		cfg.EmbeddingsApiKey = "sk-embed"
+	})
+
+	types := map[string]string{}
+	for _, entry := range s.availableModels(context.Background(), s.current()) {
+		types[entry["id"].(string)] = entry["capabilities"].(gin.H)["type"].(string)
+	}
+	if gotKey.Load() != "Bearer sk-embed" {
+		t.Errorf("embeddings 上游的 Authorization = %v", gotKey.Load())
+	}
+	// 只在 embeddings 上游出现的模型为 embeddings 类型，同名模型保留 chat 上游的类型
+	if types["bge-m3"] != modelTypeEmbeddings || types["gpt-4o"] != modelTypeChat {
+		t.Errorf("模型类型 = %v", types)
+	}
+}
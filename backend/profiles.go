@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultProfileName 默认 profile 名称，旧版扁平配置迁移后使用
+const defaultProfileName = "default"
+
+// maxProfileNameLength profile 名称的最大长度
+const maxProfileNameLength = 64
+
+// ProfileList 供前端和托盘菜单展示的 profile 列表
+type ProfileList struct {
+	Active   string   `json:"active"`
+	Profiles []string `json:"profiles"`
+}
+
+func (f *configFile) profileList() ProfileList {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return ProfileList{
+		Active:   f.ActiveProfile,
+		Profiles: names,
+	}
+}
+
+func checkProfileName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("profile 名称不能为空")
+	}
+	if strings.TrimSpace(name) != name {
+		return errors.New("profile 名称首尾不能有空格")
+	}
+	if utf8.RuneCountInString(name) > maxProfileNameLength {
+		return errors.New("profile 名称过长")
+	}
+	return nil
+}
+
+// updateProfiles 读取配置文件，执行修改后写回，并返回最新的 profile 列表
+func updateProfiles(msg string, update func(doc *configFile) error) ResponseData {
+	doc, err := readConfigDocument()
+	if errors.Is(err, os.ErrNotExist) {
+		doc, err = newConfigFile(), nil
+	}
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件读取错误: " + err.Error(),
+		}
+	}
+
+	if err := update(doc); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    err.Error(),
+		}
+	}
+
+	if err := writeConfigDocument(doc); err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件写入错误: " + err.Error(),
+		}
+	}
+
+	return ResponseData{
+		Status: "success",
+		Data:   doc.profileList(),
+		Msg:    msg,
+	}
+}
+
+func ListProfiles() ResponseData {
+	doc, err := readConfigDocument()
+	if errors.Is(err, os.ErrNotExist) {
+		doc, err = newConfigFile(), nil
+	}
+	if err != nil {
+		return ResponseData{
+			Status: "fail",
+			Msg:    "配置文件读取错误: " + err.Error(),
+		}
+	}
+
+	return ResponseData{
+		Status: "success",
+		Data:   doc.profileList(),
+		Msg:    "获取 profile 列表成功",
+	}
+}
+
+// CreateProfile 使用默认配置新建 profile
+func CreateProfile(name string) ResponseData {
+	return updateProfiles("profile 已创建", func(doc *configFile) error {
+		if err := checkProfileName(name); err != nil {
+			return err
+		}
+		if _, exists := doc.Profiles[name]; exists {
+			return errors.New("profile 已存在: " + name)
+		}
+
+		doc.Profiles[name] = defaultConfig()
+		return nil
+	})
+}
+
+// CloneProfile 复制已有 profile 的全部配置
+func CloneProfile(source, name string) ResponseData {
+	return updateProfiles("profile 已复制", func(doc *configFile) error {
+		if err := checkProfileName(name); err != nil {
+			return err
+		}
+		cfg, ok := doc.Profiles[source]
+		if !ok {
+			return errors.New("profile 不存在: " + source)
+		}
+		if _, exists := doc.Profiles[name]; exists {
+			return errors.New("profile 已存在: " + name)
+		}
+
+		doc.Profiles[name] = cloneConfig(cfg)
+		return nil
+	})
+}
+
+// DeleteProfile 删除 profile，当前激活的 profile 不能删除
+func DeleteProfile(name string) ResponseData {
+	return updateProfiles("profile 已删除", func(doc *configFile) error {
+		if _, ok := doc.Profiles[name]; !ok {
+			return errors.New("profile 不存在: " + name)
+		}
+		if name == doc.ActiveProfile {
+			return errors.New("不能删除当前使用中的 profile")
+		}
+
+		delete(doc.Profiles, name)
+		return nil
+	})
+}
+
+// ActivateProfile 切换当前使用的 profile
+func ActivateProfile(name string) ResponseData {
+	return updateProfiles("已切换到 profile: "+name, func(doc *configFile) error {
+		if _, ok := doc.Profiles[name]; !ok {
+			return errors.New("profile 不存在: " + name)
+		}
+
+		doc.ActiveProfile = name
+		return nil
+	})
+}
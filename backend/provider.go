@@ -0,0 +1,186 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Route 代理的请求类型，Provider 根据它决定上游地址和请求格式
+type Route string
+
+const (
+	// RouteChat Copilot Chat 请求，请求体为 OpenAI chat/completions 格式
+	RouteChat Route = "chat"
+	// RouteCodex 代码补全请求，请求体为 OpenAI completions 格式，包含 prompt 和 suffix
+	RouteCodex Route = "codex"
+	// RouteEmbeddings 向量请求，请求体为 OpenAI embeddings 格式，input 为字符串数组
+	RouteEmbeddings Route = "embeddings"
+)
+
+// DefaultProviderName 未配置 provider 时使用的 OpenAI 兼容实现
+const DefaultProviderName = "openai"
+
+// Upstream 某个路由的上游连接信息
+type Upstream struct {
+	// Name 日志中显示的名称，为空时显示 Base
+	Name         string
+	Provider     string
+	Base         string
+	Key          string
+	Organization string
+	Project      string
+	// APIVersion 需要版本参数的上游使用，例如 Azure OpenAI 的 api-version
+	APIVersion string
+	// ModelMap 发往该上游前对请求中的模型名再做一次映射，"*" 匹配其他所有模型
+	ModelMap map[string]string
+	// Weight weighted 负载均衡策略下的权重，小于等于 0 时按 1 计算
+	Weight int
+}
+
+// label 日志中显示的上游名称
+func (up Upstream) label() string {
+	if up.Name != "" {
+		return up.Name
+	}
+	return up.Base
+}
+
+// Provider 对接一种上游 API。请求体在进入 Provider 前已经完成模型映射等处理，仍为 OpenAI 格式，
+// Provider 负责构造上游 URL、鉴权头和请求体，并把上游响应转换回 OpenAI 格式。
+type Provider interface {
+	// Name 配置中 chat_provider、codex_provider 使用的名称
+	Name() string
+	// NewRequest 根据 OpenAI 格式的请求体构造发往上游的请求
+	NewRequest(ctx context.Context, route Route, up Upstream, body []byte) (*http.Request, error)
+	// TranslateResponse 将上游响应转换为 OpenAI 格式，流式响应应边读边转换
+	TranslateResponse(route Route, resp *http.Response) (*http.Response, error)
+}
+
+// ModelLister 可选接口，能够从上游获取可用模型列表的 Provider 实现它
+type ModelLister interface {
+	ListModels(ctx context.Context, client *http.Client, up Upstream) ([]string, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+func init() {
+	RegisterProvider(openAIProvider{})
+}
+
+// RegisterProvider 注册 Provider，同名时覆盖
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// providerFor 按名称查找 Provider，名称为空时使用 OpenAI 兼容实现
+func providerFor(name string) (Provider, error) {
+	if name == "" {
+		name = DefaultProviderName
+	}
+
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的 provider: %s", name)
+	}
+	return p, nil
+}
+
+// providerNames 返回已注册的 provider 名称，用于校验和错误提示
+func providerNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// forward 通过路由对应的 Provider 请求上游，返回已转换为 OpenAI 格式的响应
+func forward(ctx context.Context, client *http.Client, route Route, up Upstream, body []byte) (*http.Response, error) {
+	p, err := providerFor(up.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := p.NewRequest(ctx, route, up, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	translated, err := p.TranslateResponse(route, resp)
+	if err != nil {
+		closeIO(resp.Body)
+		return nil, err
+	}
+	return translated, nil
+}
+
+// openAIProvider OpenAI 及兼容 OpenAI 接口的服务，例如 DeepSeek
+type openAIProvider struct{}
+
+func (openAIProvider) Name() string {
+	return DefaultProviderName
+}
+
+func (openAIProvider) NewRequest(ctx context.Context, route Route, up Upstream, body []byte) (*http.Request, error) {
+	path := "/chat/completions"
+	switch route {
+	case RouteCodex:
+		path = "/completions"
+	case RouteEmbeddings:
+		path = "/embeddings"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.Base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	setOpenAIAuth(req, up)
+	return req, nil
+}
+
+func (openAIProvider) TranslateResponse(_ Route, resp *http.Response) (*http.Response, error) {
+	return resp, nil
+}
+
+// ListModels 通过 /models 获取上游可用的模型
+func (openAIProvider) ListModels(ctx context.Context, client *http.Client, up Upstream) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, up.Base+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	setOpenAIAuth(req, up)
+	return listOpenAIModels(client, req)
+}
+
+// setOpenAIAuth 设置 OpenAI 风格的 Bearer 鉴权以及组织、项目头
+func setOpenAIAuth(req *http.Request, up Upstream) {
+	req.Header.Set("Authorization", "Bearer "+up.Key)
+	if up.Organization != "" {
+		req.Header.Set("OpenAI-Organization", up.Organization)
+	}
+	if up.Project != "" {
+		req.Header.Set("OpenAI-Project", up.Project)
+	}
+}
@@ -0,0 +1,457 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// anthropicVersion 请求头 anthropic-version 的取值
+const anthropicVersion = "2023-06-01"
+
+// anthropicDefaultMaxTokens Anthropic 要求必须指定 max_tokens，请求中缺失时使用该值
+const anthropicDefaultMaxTokens = 4096
+
+func init() {
+	RegisterProvider(anthropicProvider{})
+}
+
+// anthropicProvider Anthropic Messages API，api base 形如 https://api.anthropic.com/v1
+type anthropicProvider struct{}
+
+func (anthropicProvider) Name() string {
+	return "anthropic"
+}
+
+func (anthropicProvider) NewRequest(ctx context.Context, route Route, up Upstream, body []byte) (*http.Request, error) {
+	switch route {
+	case RouteCodex:
+		return nil, errors.New("anthropic 不支持代码补全接口")
+	case RouteEmbeddings:
+		return nil, errors.New("anthropic 不支持 embeddings 接口")
+	}
+
+	payload, err := anthropicRequestBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.Base+"/messages", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", up.Key)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	return req, nil
+}
+
+func (anthropicProvider) TranslateResponse(_ Route, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != http.StatusOK {
+		return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+			message := gjson.GetBytes(body, "error.message").String()
+			if message == "" {
+				message = string(body)
+			}
+			return openAIError(message, gjson.GetBytes(body, "error.type").String()), nil
+		})
+	}
+
+	if isEventStream(resp) {
+		return pipeResponse(resp, "text/event-stream", translateAnthropicStream), nil
+	}
+	return replaceJSONBody(resp, translateAnthropicMessage)
+}
+
+// ListModels 通过 /models 获取可用的模型
+func (anthropicProvider) ListModels(ctx context.Context, client *http.Client, up Upstream) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, up.Base+"/models?limit=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", up.Key)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	return listOpenAIModels(client, req)
+}
+
+// anthropicRequestBody 将 OpenAI chat/completions 请求体转换为 Anthropic messages 请求体
+func anthropicRequestBody(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+	out := map[string]interface{}{
+		"model":      req.Get("model").String(),
+		"max_tokens": anthropicDefaultMaxTokens,
+	}
+	if maxTokens := req.Get("max_tokens").Int(); maxTokens > 0 {
+		out["max_tokens"] = maxTokens
+	}
+	if req.Get("stream").Bool() {
+		out["stream"] = true
+	}
+	for _, key := range []string{"temperature", "top_p"} {
+		if v := req.Get(key); v.Exists() && v.Type != gjson.Null {
+			out[key] = v.Value()
+		}
+	}
+	if stop := req.Get("stop"); stop.Exists() && stop.Type != gjson.Null {
+		var sequences []string
+		if stop.IsArray() {
+			for _, s := range stop.Array() {
+				sequences = append(sequences, s.String())
+			}
+		} else {
+			sequences = []string{stop.String()}
+		}
+		out["stop_sequences"] = sequences
+	}
+	if user := req.Get("user").String(); user != "" {
+		out["metadata"] = map[string]interface{}{"user_id": user}
+	}
+
+	system, messages, err := anthropicMessages(req.Get("messages").Array())
+	if err != nil {
+		return nil, err
+	}
+	if system != "" {
+		out["system"] = system
+	}
+	out["messages"] = messages
+
+	if tools := anthropicTools(req); len(tools) > 0 {
+		out["tools"] = tools
+		if choice := anthropicToolChoice(req); choice != nil {
+			out["tool_choice"] = choice
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// anthropicMessages 提取 system 消息，并将其余消息转换为 user/assistant 交替的内容块
+func anthropicMessages(messages []gjson.Result) (string, []map[string]interface{}, error) {
+	var system []string
+	var out []map[string]interface{}
+	// lastCallID 旧版 function_call 没有 id，用于关联随后的 function 结果
+	lastCallID := ""
+
+	appendBlocks := func(role string, blocks []interface{}) {
+		if len(blocks) == 0 {
+			return
+		}
+		// Anthropic 要求 user 和 assistant 交替出现，相邻的同角色消息合并
+		if n := len(out); n > 0 && out[n-1]["role"] == role {
+			out[n-1]["content"] = append(out[n-1]["content"].([]interface{}), blocks...)
+			return
+		}
+		out = append(out, map[string]interface{}{"role": role, "content": blocks})
+	}
+
+	for i, msg := range messages {
+		role := msg.Get("role").String()
+		switch role {
+		case "system", "developer":
+			system = append(system, messageText(msg.Get("content")))
+
+		case "user":
+			appendBlocks("user", anthropicContent(msg.Get("content")))
+
+		case "assistant":
+			blocks := anthropicContent(msg.Get("content"))
+			for _, call := range msg.Get("tool_calls").Array() {
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call.Get("id").String(),
+					"name":  call.Get("function.name").String(),
+					"input": toolArguments(call.Get("function.arguments").String()),
+				})
+			}
+			if call := msg.Get("function_call"); call.Exists() {
+				lastCallID = fmt.Sprintf("call_%d", i)
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    lastCallID,
+					"name":  call.Get("name").String(),
+					"input": toolArguments(call.Get("arguments").String()),
+				})
+			}
+			appendBlocks("assistant", blocks)
+
+		case "tool", "function":
+			id := msg.Get("tool_call_id").String()
+			if role == "function" {
+				id = lastCallID
+			}
+			appendBlocks("user", []interface{}{map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": id,
+				"content":     messageText(msg.Get("content")),
+			}})
+
+		default:
+			return "", nil, fmt.Errorf("不支持的消息角色: %s", role)
+		}
+	}
+
+	if len(out) == 0 {
+		return "", nil, errors.New("请求中没有可发送的消息")
+	}
+	return strings.Join(system, "\n\n"), out, nil
+}
+
+// anthropicContent 将 OpenAI 的字符串或内容数组转换为 Anthropic 内容块
+func anthropicContent(content gjson.Result) []interface{} {
+	if !content.IsArray() {
+		if text := content.String(); text != "" {
+			return []interface{}{map[string]interface{}{"type": "text", "text": text}}
+		}
+		return nil
+	}
+
+	var blocks []interface{}
+	for _, part := range content.Array() {
+		switch part.Get("type").String() {
+		case "text":
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": part.Get("text").String()})
+		case "image_url":
+			mediaType, data, ok := parseDataURL(part.Get("image_url.url").String())
+			if !ok {
+				continue
+			}
+			blocks = append(blocks, map[string]interface{}{
+				"type": "image",
+				"source": map[string]interface{}{
+					"type":       "base64",
+					"media_type": mediaType,
+					"data":       data,
+				},
+			})
+		}
+	}
+	return blocks
+}
+
+// messageText 取出消息中的纯文本，内容数组中的文本依次拼接
+func messageText(content gjson.Result) string {
+	if !content.IsArray() {
+		return content.String()
+	}
+
+	var parts []string
+	for _, part := range content.Array() {
+		if part.Get("type").String() == "text" {
+			parts = append(parts, part.Get("text").String())
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// parseDataURL 解析 data:image/png;base64,xxx 形式的图片
+func parseDataURL(raw string) (string, string, bool) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(raw, "data:"), ",")
+	if !ok || !strings.HasPrefix(raw, "data:") || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), data, true
+}
+
+// toolArguments 将 JSON 字符串形式的函数参数解析为对象，解析失败时返回空对象
+func toolArguments(arguments string) interface{} {
+	var input map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &input); err != nil || input == nil {
+		return map[string]interface{}{}
+	}
+	return input
+}
+
+// anthropicTools 转换 tools 以及旧版的 functions 定义
+func anthropicTools(req gjson.Result) []map[string]interface{} {
+	var defs []gjson.Result
+	for _, tool := range req.Get("tools").Array() {
+		if tool.Get("type").String() == "function" {
+			defs = append(defs, tool.Get("function"))
+		}
+	}
+	defs = append(defs, req.Get("functions").Array()...)
+
+	tools := make([]map[string]interface{}, 0, len(defs))
+	for _, def := range defs {
+		schema := def.Get("parameters").Value()
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		tool := map[string]interface{}{
+			"name":         def.Get("name").String(),
+			"input_schema": schema,
+		}
+		if description := def.Get("description").String(); description != "" {
+			tool["description"] = description
+		}
+		tools = append(tools, tool)
+	}
+	return tools
+}
+
+// anthropicToolChoice 转换 tool_choice 以及旧版的 function_call
+func anthropicToolChoice(req gjson.Result) interface{} {
+	choice := req.Get("tool_choice")
+	if !choice.Exists() {
+		choice = req.Get("function_call")
+	}
+
+	switch {
+	case !choice.Exists():
+		return nil
+	case choice.String() == "auto":
+		return map[string]interface{}{"type": "auto"}
+	case choice.String() == "required":
+		return map[string]interface{}{"type": "any"}
+	case choice.String() == "none":
+		return map[string]interface{}{"type": "none"}
+	case choice.Get("function.name").Exists():
+		return map[string]interface{}{"type": "tool", "name": choice.Get("function.name").String()}
+	case choice.Get("name").Exists():
+		return map[string]interface{}{"type": "tool", "name": choice.Get("name").String()}
+	}
+	return nil
+}
+
+// anthropicFinishReason 将 stop_reason 转换为 OpenAI 的 finish_reason
+func anthropicFinishReason(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "":
+		return ""
+	}
+	return "stop"
+}
+
+// translateAnthropicMessage 将非流式的 Anthropic 响应转换为 chat.completion
+func translateAnthropicMessage(body []byte) ([]byte, error) {
+	msg := gjson.ParseBytes(body)
+
+	var text []string
+	var toolCalls []map[string]interface{}
+	for _, block := range msg.Get("content").Array() {
+		switch block.Get("type").String() {
+		case "text":
+			text = append(text, block.Get("text").String())
+		case "tool_use":
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block.Get("id").String(),
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block.Get("name").String(),
+					"arguments": block.Get("input").Raw,
+				},
+			})
+		}
+	}
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": strings.Join(text, ""),
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	inputTokens := msg.Get("usage.input_tokens").Int()
+	outputTokens := msg.Get("usage.output_tokens").Int()
+	return json.Marshal(map[string]interface{}{
+		"id":      msg.Get("id").String(),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   msg.Get("model").String(),
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"message":       message,
+			"finish_reason": anthropicFinishReason(msg.Get("stop_reason").String()),
+		}},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     inputTokens,
+			"completion_tokens": outputTokens,
+			"total_tokens":      inputTokens + outputTokens,
+		},
+	})
+}
+
+// translateAnthropicStream 将 Anthropic 的 SSE 事件转换为 chat.completion.chunk
+func translateAnthropicStream(src io.Reader, dst io.Writer) error {
+	var chunks *chunkWriter
+	// toolIndex Anthropic 内容块下标到 OpenAI tool_calls 下标的映射
+	toolIndex := map[int64]int{}
+
+	return readSSE(src, func(ev sseEvent) error {
+		data := gjson.ParseBytes(ev.Data)
+		if chunks == nil {
+			chunks = newChunkWriter(dst, data.Get("message.model").String())
+			if id := data.Get("message.id").String(); id != "" {
+				chunks.id = id
+			}
+		}
+
+		switch data.Get("type").String() {
+		case "message_start":
+			return chunks.write(map[string]interface{}{"role": "assistant", "content": ""}, "")
+
+		case "content_block_start":
+			block := data.Get("content_block")
+			if block.Get("type").String() != "tool_use" {
+				return nil
+			}
+			index := len(toolIndex)
+			toolIndex[data.Get("index").Int()] = index
+			return chunks.write(map[string]interface{}{
+				"tool_calls": []interface{}{map[string]interface{}{
+					"index": index,
+					"id":    block.Get("id").String(),
+					"type":  "function",
+					"function": map[string]interface{}{
+						"name":      block.Get("name").String(),
+						"arguments": "",
+					},
+				}},
+			}, "")
+
+		case "content_block_delta":
+			delta := data.Get("delta")
+			switch delta.Get("type").String() {
+			case "text_delta":
+				return chunks.write(map[string]interface{}{"content": delta.Get("text").String()}, "")
+			case "input_json_delta":
+				return chunks.write(map[string]interface{}{
+					"tool_calls": []interface{}{map[string]interface{}{
+						"index":    toolIndex[data.Get("index").Int()],
+						"function": map[string]interface{}{"arguments": delta.Get("partial_json").String()},
+					}},
+				}, "")
+			}
+
+		case "message_delta":
+			if reason := anthropicFinishReason(data.Get("delta.stop_reason").String()); reason != "" {
+				return chunks.write(map[string]interface{}{}, reason)
+			}
+
+		case "message_stop":
+			return chunks.done()
+
+		case "error":
+			if err := chunks.writeRaw(json.RawMessage(openAIError(data.Get("error.message").String(), data.Get("error.type").String()))); err != nil {
+				return err
+			}
+			return chunks.done()
+		}
+		return nil
+	})
+}
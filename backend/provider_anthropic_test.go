@@ -0,0 +1,189 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestAnthropicRequestBody(t *testing.T) {
+	got, base := fixedUpstream(t, http.StatusOK, "application/json", `{"content":[]}`)
+
+	forwardBody(t, RouteChat, Upstream{Provider: "anthropic", Base: base, Key: "sk-ant"}, `{
+		"model": "claude-sonnet",
+		"stream": false,
+		"temperature": 0.5,
+		"stop": "END",
+		"user": "u-1",
+		"messages": [
+			{"role": "system", "content": "You are helpful."},
+			{"role": "developer", "content": [{"type": "text", "text": "Answer briefly."}]},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is in this image?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBOR"}}
+			]},
+			{"role": "assistant", "content": "Let me check.", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "sunny"},
+			{"role": "user", "content": "Thanks"}
+		],
+		"tools": [
+			{"type": "function", "function": {"name": "get_weather", "description": "Get weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}},
+			{"type": "function", "function": {"name": "no_params"}}
+		],
+		"tool_choice": "required"
+	}`)
+
+	if got.path != "/messages" {
+		t.Errorf("path = %s", got.path)
+	}
+	if got.header.Get("x-api-key") != "sk-ant" || got.header.Get("anthropic-version") != anthropicVersion {
+		t.Errorf("请求头: x-api-key %q, anthropic-version %q", got.header.Get("x-api-key"), got.header.Get("anthropic-version"))
+	}
+	// system 和 developer 合并为 system，tool 结果与其后的 user 消息合并，保证 user/assistant 交替
+	assertJSONEqual(t, got.body, `{
+		"model": "claude-sonnet",
+		"max_tokens": 4096,
+		"temperature": 0.5,
+		"stop_sequences": ["END"],
+		"metadata": {"user_id": "u-1"},
+		"system": "You are helpful.\n\nAnswer briefly.",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is in this image?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBOR"}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Let me check."},
+				{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "call_1", "content": "sunny"},
+				{"type": "text", "text": "Thanks"}
+			]}
+		],
+		"tools": [
+			{"name": "get_weather", "description": "Get weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}},
+			{"name": "no_params", "input_schema": {"type": "object", "properties": {}}}
+		],
+		"tool_choice": {"type": "any"}
+	}`)
+}
+
+func TestAnthropicLegacyFunctions(t *testing.T) {
+	got, base := fixedUpstream(t, http.StatusOK, "application/json", `{"content":[]}`)
+
+	forwardBody(t, RouteChat, Upstream{Provider: "anthropic", Base: base}, `{
+		"model": "claude-sonnet",
+		"max_tokens": 100,
+		"messages": [
+			{"role": "user", "content": "weather?"},
+			{"role": "assistant", "content": null, "function_call": {"name": "get_weather", "arguments": "not json"}},
+			{"role": "function", "name": "get_weather", "content": "sunny"}
+		],
+		"functions": [{"name": "get_weather", "parameters": {"type": "object"}}],
+		"function_call": {"name": "get_weather"}
+	}`)
+
+	assertJSONEqual(t, got.body, `{
+		"model": "claude-sonnet",
+		"max_tokens": 100,
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "weather?"}]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "call_1", "content": "sunny"}]}
+		],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "tool", "name": "get_weather"}
+	}`)
+}
+
+func TestAnthropicUnsupportedRoutes(t *testing.T) {
+	for _, route := range []Route{RouteCodex, RouteEmbeddings} {
+		if _, err := (anthropicProvider{}).NewRequest(context.Background(), route, Upstream{}, []byte(`{}`)); err == nil {
+			t.Errorf("%s 应返回错误", route)
+		}
+	}
+	if _, err := (anthropicProvider{}).NewRequest(context.Background(), RouteChat, Upstream{}, []byte(`{"messages":[{"role":"system","content":"x"}]}`)); err == nil {
+		t.Error("只有 system 消息时应返回错误")
+	}
+}
+
+func TestAnthropicResponse(t *testing.T) {
+	_, base := fixedUpstream(t, http.StatusOK, "application/json", `{
+		"id": "msg_1",
+		"model": "claude-sonnet",
+		"content": [
+			{"type": "text", "text": "Checking "},
+			{"type": "text", "text": "now."},
+			{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 5}
+	}`)
+
+	_, out := forwardBody(t, RouteChat, Upstream{Provider: "anthropic", Base: base}, `{"messages":[{"role":"user","content":"hi"}]}`)
+	resp := gjson.ParseBytes(out)
+	if resp.Get("id").String() != "msg_1" || resp.Get("object").String() != "chat.completion" || resp.Get("model").String() != "claude-sonnet" {
+		t.Errorf("响应头部字段: %s", out)
+	}
+	assertJSONEqual(t, []byte(resp.Get("choices").Raw), `[{
+		"index": 0,
+		"message": {
+			"role": "assistant",
+			"content": "Checking now.",
+			"tool_calls": [{"id": "toolu_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}}]
+		},
+		"finish_reason": "tool_calls"
+	}]`)
+	assertJSONEqual(t, []byte(resp.Get("usage").Raw), `{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}`)
+}
+
+func TestAnthropicStream(t *testing.T) {
+	stream := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet"}}` + "\n\n" +
+		": ping\n\n" +
+		"event: content_block_start\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}` + "\n\n" +
+		"event: content_block_start\n" +
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}` + "\n\n" +
+		"event: message_delta\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}` + "\n\n" +
+		"event: message_stop\n" +
+		`data: {"type":"message_stop"}` + "\n\n"
+	_, base := fixedUpstream(t, http.StatusOK, "text/event-stream", stream)
+
+	_, out := forwardBody(t, RouteChat, Upstream{Provider: "anthropic", Base: base}, `{"messages":[{"role":"user","content":"hi"}],"stream":true}`)
+	assertJSONEqual(t, []byte(streamChoices(t, out)), `[
+		{"delta": {"role": "assistant", "content": ""}, "finish_reason": null},
+		{"delta": {"content": "Hi"}, "finish_reason": null},
+		{"delta": {"tool_calls": [{"index": 0, "id": "toolu_1", "type": "function", "function": {"name": "get_weather", "arguments": ""}}]}, "finish_reason": null},
+		{"delta": {"tool_calls": [{"index": 0, "function": {"arguments": "{\"city\":"}}]}, "finish_reason": null},
+		{"delta": {"tool_calls": [{"index": 0, "function": {"arguments": "\"Paris\"}"}}]}, "finish_reason": null},
+		{"delta": {}, "finish_reason": "tool_calls"}
+	]`)
+	if id := gjson.Get(firstData(t, out), "id").String(); id != "msg_1" {
+		t.Errorf("chunk id = %s，期望沿用 message id", id)
+	}
+}
+
+func TestAnthropicStreamError(t *testing.T) {
+	stream := `data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet"}}` + "\n\n" +
+		`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}` + "\n\n"
+	_, base := fixedUpstream(t, http.StatusOK, "text/event-stream", stream)
+
+	_, out := forwardBody(t, RouteChat, Upstream{Provider: "anthropic", Base: base}, `{"messages":[{"role":"user","content":"hi"}],"stream":true}`)
+	assertJSONEqual(t, []byte(streamChoices(t, out)), `[
+		{"delta": {"role": "assistant", "content": ""}, "finish_reason": null},
+		`+string(openAIError("Overloaded", "overloaded_error"))+`
+	]`)
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// azureDefaultAPIVersion 未配置 chat_api_version、codex_api_version 时使用的 api-version
+const azureDefaultAPIVersion = "2024-06-01"
+
+func init() {
+	RegisterProvider(azureProvider{})
+}
+
+// azureProvider Azure OpenAI，api base 为资源的 endpoint，例如 https://xxx.openai.azure.com。
+// 请求体中的模型名（经过 chat_model_map 映射后）作为部署名。
+type azureProvider struct{}
+
+func (azureProvider) Name() string {
+	return "azure"
+}
+
+func (azureProvider) NewRequest(ctx context.Context, route Route, up Upstream, body []byte) (*http.Request, error) {
+	deployment := gjson.GetBytes(body, "model").String()
+	if deployment == "" {
+		return nil, errors.New("azure 请求缺少部署名")
+	}
+
+	path := "chat/completions"
+	switch route {
+	case RouteCodex:
+		path = "completions"
+	case RouteEmbeddings:
+		path = "embeddings"
+	}
+	version := up.APIVersion
+	if version == "" {
+		version = azureDefaultAPIVersion
+	}
+	endpoint := fmt.Sprintf("%s/openai/deployments/%s/%s?api-version=%s",
+		strings.TrimSuffix(up.Base, "/"), url.PathEscape(deployment), path, url.QueryEscape(version))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", up.Key)
+	return req, nil
+}
+
+func (azureProvider) TranslateResponse(_ Route, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+		data := gjson.ParseBytes(body)
+		if data.Get("error.code").String() != "content_filter" {
+			message := data.Get("error.message").String()
+			if message == "" {
+				return body, nil
+			}
+			return openAIError(message, data.Get("error.code").String()), nil
+		}
+		return openAIError(contentFilterMessage(data.Get("error")), "content_filter"), nil
+	})
+}
+
+// contentFilterMessage 将 Azure 内容过滤的结果整理为可读的提示，例如 "请求被 Azure 内容过滤拦截: hate(high), violence(medium)"
+func contentFilterMessage(apiErr gjson.Result) string {
+	var categories []string
+	apiErr.Get("innererror.content_filter_result").ForEach(func(key, value gjson.Result) bool {
+		if value.Get("filtered").Bool() {
+			category := key.String()
+			if severity := value.Get("severity").String(); severity != "" {
+				category += "(" + severity + ")"
+			}
+			categories = append(categories, category)
+		}
+		return true
+	})
+	sort.Strings(categories)
+
+	if len(categories) == 0 {
+		return "请求被 Azure 内容过滤拦截: " + apiErr.Get("message").String()
+	}
+	return "请求被 Azure 内容过滤拦截: " + strings.Join(categories, ", ")
+}
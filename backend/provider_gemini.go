@@ -0,0 +1,482 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// geminiFIMTemplate Gemini 没有 FIM 接口，代码补全时把前后文填入该模板，要求模型只输出中间缺失的代码
+const geminiFIMTemplate = "You are a code completion engine. Output only the code that should replace <FILL_HERE>, " +
+	"without explanations, markdown fences, or repeating the surrounding code.\n\n{prefix}<FILL_HERE>{suffix}"
+
+func init() {
+	RegisterProvider(geminiProvider{})
+}
+
+// geminiProvider Google Gemini generateContent 接口，api base 形如 https://generativelanguage.googleapis.com/v1beta
+type geminiProvider struct{}
+
+func (geminiProvider) Name() string {
+	return "gemini"
+}
+
+func (geminiProvider) NewRequest(ctx context.Context, route Route, up Upstream, body []byte) (*http.Request, error) {
+	var payload []byte
+	var err error
+	switch route {
+	case RouteCodex:
+		payload, err = geminiCodexBody(body)
+	case RouteEmbeddings:
+		payload, err = geminiEmbedBody(body)
+	default:
+		payload, err = geminiChatBody(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	method := "generateContent"
+	if route == RouteEmbeddings {
+		method = "batchEmbedContents"
+	} else if gjson.GetBytes(body, "stream").Bool() {
+		method = "streamGenerateContent"
+		query.Set("alt", "sse")
+	}
+	model := gjson.GetBytes(body, "model").String()
+	endpoint := fmt.Sprintf("%s/models/%s:%s", up.Base, url.PathEscape(model), method)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setGeminiAuth(req, up)
+	return req, nil
+}
+
+// setGeminiAuth 通过请求头传递 API key。放在 URL 中时，连接失败的错误信息会带上完整 URL，key 会被写进日志
+func setGeminiAuth(req *http.Request, up Upstream) {
+	req.Header.Set("x-goog-api-key", up.Key)
+}
+
+func (geminiProvider) TranslateResponse(route Route, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != http.StatusOK {
+		return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+			message := gjson.GetBytes(body, "error.message").String()
+			if message == "" {
+				message = string(body)
+			}
+			return openAIError(message, gjson.GetBytes(body, "error.status").String()), nil
+		})
+	}
+
+	model := geminiModelFromPath(resp.Request.URL.Path)
+	if route == RouteEmbeddings {
+		return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+			var vectors []json.RawMessage
+			for _, embedding := range gjson.GetBytes(body, "embeddings").Array() {
+				vectors = append(vectors, json.RawMessage(embedding.Get("values").Raw))
+			}
+			return embeddingList(model, vectors, 0)
+		})
+	}
+	if route == RouteCodex {
+		if isEventStream(resp) {
+			return pipeResponse(resp, "text/event-stream", func(src io.Reader, dst io.Writer) error {
+				return translateGeminiCompletionStream(src, dst, model)
+			}), nil
+		}
+		return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+			text, reason := geminiCandidateText(gjson.ParseBytes(body))
+			return json.Marshal(textCompletion(fmt.Sprintf("cmpl-%d", time.Now().UnixNano()), model, time.Now().Unix(), text, reason))
+		})
+	}
+
+	if isEventStream(resp) {
+		return pipeResponse(resp, "text/event-stream", func(src io.Reader, dst io.Writer) error {
+			return translateGeminiStream(src, dst, model)
+		}), nil
+	}
+	return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+		return translateGeminiResponse(body, model)
+	})
+}
+
+// ListModels 通过 /models 获取可用的模型，返回的名称去掉 "models/" 前缀
+func (geminiProvider) ListModels(ctx context.Context, client *http.Client, up Upstream) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, up.Base+"/models?pageSize=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+	setGeminiAuth(req, up)
+
+	body, err := fetchJSON(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, model := range gjson.GetBytes(body, "models").Array() {
+		names = append(names, strings.TrimPrefix(model.Get("name").String(), "models/"))
+	}
+	return names, nil
+}
+
+// geminiEmbedBody 将 embeddings 请求体转换为 batchEmbedContents 请求体，每个 input 对应一个请求
+func geminiEmbedBody(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+	inputs, err := embeddingTexts(req.Get("input"))
+	if err != nil {
+		return nil, err
+	}
+
+	model := "models/" + req.Get("model").String()
+	requests := make([]interface{}, 0, len(inputs))
+	for _, input := range inputs {
+		item := map[string]interface{}{
+			"model":   model,
+			"content": map[string]interface{}{"parts": []interface{}{map[string]string{"text": input}}},
+		}
+		if dimensions := req.Get("dimensions").Int(); dimensions > 0 {
+			item["outputDimensionality"] = dimensions
+		}
+		requests = append(requests, item)
+	}
+	return json.Marshal(map[string]interface{}{"requests": requests})
+}
+
+// geminiModelFromPath 从 /models/{model}:method 中取出模型名
+func geminiModelFromPath(path string) string {
+	_, rest, _ := strings.Cut(path, "/models/")
+	model, _, _ := strings.Cut(rest, ":")
+	return model
+}
+
+// geminiGenerationConfig 转换采样参数
+func geminiGenerationConfig(req gjson.Result) map[string]interface{} {
+	cfg := map[string]interface{}{}
+	if maxTokens := req.Get("max_tokens").Int(); maxTokens > 0 {
+		cfg["maxOutputTokens"] = maxTokens
+	}
+	if v := req.Get("temperature"); v.Exists() && v.Type != gjson.Null {
+		cfg["temperature"] = v.Value()
+	}
+	if v := req.Get("top_p"); v.Exists() && v.Type != gjson.Null {
+		cfg["topP"] = v.Value()
+	}
+	if stop := req.Get("stop"); stop.Exists() && stop.Type != gjson.Null {
+		var sequences []string
+		if stop.IsArray() {
+			for _, s := range stop.Array() {
+				sequences = append(sequences, s.String())
+			}
+		} else {
+			sequences = []string{stop.String()}
+		}
+		cfg["stopSequences"] = sequences
+	}
+	return cfg
+}
+
+// geminiChatBody 将 OpenAI chat/completions 请求体转换为 Gemini generateContent 请求体
+func geminiChatBody(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+
+	var system []string
+	var contents []map[string]interface{}
+	// toolNames tool_call_id 到函数名的映射，Gemini 的 functionResponse 需要函数名
+	toolNames := map[string]string{}
+	lastFunction := ""
+
+	appendParts := func(role string, parts []interface{}) {
+		if len(parts) == 0 {
+			return
+		}
+		if n := len(contents); n > 0 && contents[n-1]["role"] == role {
+			contents[n-1]["parts"] = append(contents[n-1]["parts"].([]interface{}), parts...)
+			return
+		}
+		contents = append(contents, map[string]interface{}{"role": role, "parts": parts})
+	}
+
+	for _, msg := range req.Get("messages").Array() {
+		role := msg.Get("role").String()
+		switch role {
+		case "system", "developer":
+			system = append(system, messageText(msg.Get("content")))
+
+		case "user":
+			appendParts("user", geminiParts(msg.Get("content")))
+
+		case "assistant":
+			parts := geminiParts(msg.Get("content"))
+			for _, call := range msg.Get("tool_calls").Array() {
+				name := call.Get("function.name").String()
+				toolNames[call.Get("id").String()] = name
+				parts = append(parts, map[string]interface{}{
+					"functionCall": map[string]interface{}{
+						"name": name,
+						"args": toolArguments(call.Get("function.arguments").String()),
+					},
+				})
+			}
+			if call := msg.Get("function_call"); call.Exists() {
+				lastFunction = call.Get("name").String()
+				parts = append(parts, map[string]interface{}{
+					"functionCall": map[string]interface{}{
+						"name": lastFunction,
+						"args": toolArguments(call.Get("arguments").String()),
+					},
+				})
+			}
+			appendParts("model", parts)
+
+		case "tool", "function":
+			name := toolNames[msg.Get("tool_call_id").String()]
+			if role == "function" {
+				name = msg.Get("name").String()
+				if name == "" {
+					name = lastFunction
+				}
+			}
+			appendParts("user", []interface{}{map[string]interface{}{
+				"functionResponse": map[string]interface{}{
+					"name":     name,
+					"response": map[string]interface{}{"content": messageText(msg.Get("content"))},
+				},
+			}})
+
+		default:
+			return nil, fmt.Errorf("不支持的消息角色: %s", role)
+		}
+	}
+	if len(contents) == 0 {
+		return nil, errors.New("请求中没有可发送的消息")
+	}
+
+	out := map[string]interface{}{
+		"contents": contents,
+	}
+	if len(system) > 0 {
+		out["systemInstruction"] = map[string]interface{}{
+			"parts": []interface{}{map[string]interface{}{"text": strings.Join(system, "\n\n")}},
+		}
+	}
+	if cfg := geminiGenerationConfig(req); len(cfg) > 0 {
+		out["generationConfig"] = cfg
+	}
+
+	// 函数定义与 Anthropic 的格式只差字段名，复用同一套解析
+	if tools := anthropicTools(req); len(tools) > 0 {
+		declarations := make([]map[string]interface{}, 0, len(tools))
+		for _, tool := range tools {
+			declaration := map[string]interface{}{
+				"name":       tool["name"],
+				"parameters": tool["input_schema"],
+			}
+			if description, ok := tool["description"]; ok {
+				declaration["description"] = description
+			}
+			declarations = append(declarations, declaration)
+		}
+		out["tools"] = []interface{}{map[string]interface{}{"functionDeclarations": declarations}}
+	}
+
+	return json.Marshal(out)
+}
+
+// geminiParts 将 OpenAI 的字符串或内容数组转换为 Gemini parts
+func geminiParts(content gjson.Result) []interface{} {
+	var parts []interface{}
+	for _, block := range anthropicContent(content) {
+		block := block.(map[string]interface{})
+		switch block["type"] {
+		case "text":
+			parts = append(parts, map[string]interface{}{"text": block["text"]})
+		case "image":
+			source := block["source"].(map[string]interface{})
+			parts = append(parts, map[string]interface{}{
+				"inlineData": map[string]interface{}{
+					"mimeType": source["media_type"],
+					"data":     source["data"],
+				},
+			})
+		}
+	}
+	return parts
+}
+
+// geminiCodexBody 将代码补全请求的 prompt 和 suffix 填入默认的补全指令。
+// 请求中没有 suffix 时说明 prompt 已经按配置的 fim_templates 拼接好，直接发送。
+func geminiCodexBody(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+	prompt := req.Get("prompt").String()
+	if suffix := req.Get("suffix"); suffix.Exists() {
+		prompt = strings.NewReplacer("{prefix}", prompt, "{suffix}", suffix.String()).Replace(geminiFIMTemplate)
+	}
+
+	out := map[string]interface{}{
+		"contents": []interface{}{map[string]interface{}{
+			"role":  "user",
+			"parts": []interface{}{map[string]interface{}{"text": prompt}},
+		}},
+	}
+	if cfg := geminiGenerationConfig(req); len(cfg) > 0 {
+		out["generationConfig"] = cfg
+	}
+	return json.Marshal(out)
+}
+
+// geminiFinishReason 将 finishReason 转换为 OpenAI 的 finish_reason
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	}
+	return "stop"
+}
+
+// geminiCandidateText 取出第一个候选结果的文本和结束原因
+func geminiCandidateText(resp gjson.Result) (string, string) {
+	candidate := resp.Get("candidates.0")
+	var text strings.Builder
+	for _, part := range candidate.Get("content.parts").Array() {
+		text.WriteString(part.Get("text").String())
+	}
+	return text.String(), geminiFinishReason(candidate.Get("finishReason").String())
+}
+
+// geminiToolCalls 取出候选结果中的函数调用，Gemini 不返回调用 id，按顺序生成
+func geminiToolCalls(candidate gjson.Result, offset int) []interface{} {
+	var calls []interface{}
+	for _, part := range candidate.Get("content.parts").Array() {
+		call := part.Get("functionCall")
+		if !call.Exists() {
+			continue
+		}
+		args := call.Get("args").Raw
+		if args == "" {
+			args = "{}"
+		}
+		index := offset + len(calls)
+		calls = append(calls, map[string]interface{}{
+			"index": index,
+			"id":    fmt.Sprintf("call_%d", index),
+			"type":  "function",
+			"function": map[string]interface{}{
+				"name":      call.Get("name").String(),
+				"arguments": args,
+			},
+		})
+	}
+	return calls
+}
+
+// translateGeminiResponse 将非流式的 Gemini 响应转换为 chat.completion
+func translateGeminiResponse(body []byte, model string) ([]byte, error) {
+	resp := gjson.ParseBytes(body)
+	text, reason := geminiCandidateText(resp)
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": text,
+	}
+	if calls := geminiToolCalls(resp.Get("candidates.0"), 0); len(calls) > 0 {
+		message["tool_calls"] = calls
+		reason = "tool_calls"
+	}
+
+	promptTokens := resp.Get("usageMetadata.promptTokenCount").Int()
+	completionTokens := resp.Get("usageMetadata.candidatesTokenCount").Int()
+	return json.Marshal(map[string]interface{}{
+		"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"message":       message,
+			"finish_reason": reason,
+		}},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens,
+			"total_tokens":      promptTokens + completionTokens,
+		},
+	})
+}
+
+// translateGeminiStream 将 streamGenerateContent 的 SSE 转换为 chat.completion.chunk，结束时输出 [DONE]
+func translateGeminiStream(src io.Reader, dst io.Writer, model string) error {
+	chunks := newChunkWriter(dst, model)
+	if err := chunks.write(map[string]interface{}{"role": "assistant", "content": ""}, ""); err != nil {
+		return err
+	}
+
+	toolCalls := 0
+	err := readSSE(src, func(ev sseEvent) error {
+		data := gjson.ParseBytes(ev.Data)
+		if data.Get("error").Exists() {
+			return chunks.writeRaw(json.RawMessage(openAIError(data.Get("error.message").String(), data.Get("error.status").String())))
+		}
+
+		candidate := data.Get("candidates.0")
+		text, reason := geminiCandidateText(data)
+		if text != "" {
+			if err := chunks.write(map[string]interface{}{"content": text}, ""); err != nil {
+				return err
+			}
+		}
+		if calls := geminiToolCalls(candidate, toolCalls); len(calls) > 0 {
+			toolCalls += len(calls)
+			if err := chunks.write(map[string]interface{}{"tool_calls": calls}, ""); err != nil {
+				return err
+			}
+		}
+		if reason != "" {
+			if toolCalls > 0 {
+				reason = "tool_calls"
+			}
+			return chunks.write(map[string]interface{}{}, reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return chunks.done()
+}
+
+// translateGeminiCompletionStream 将代码补全的 SSE 转换为 text_completion 流
+func translateGeminiCompletionStream(src io.Reader, dst io.Writer, model string) error {
+	completions := newCompletionWriter(dst, model)
+	err := readSSE(src, func(ev sseEvent) error {
+		text, reason := geminiCandidateText(gjson.ParseBytes(ev.Data))
+		if text == "" && reason == "" {
+			return nil
+		}
+		return completions.write(text, reason)
+	})
+	if err != nil {
+		return err
+	}
+	return completions.done()
+}
@@ -0,0 +1,243 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+const geminiTestKey = "AIza-test-key"
+
+func TestGeminiKeyNotInURL(t *testing.T) {
+	var gotKey, gotQuery string
+	srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		gotKey, gotQuery = r.Header.Get("x-goog-api-key"), r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"candidates":[{"content":{"parts":[{"text":"hi"}]},"finishReason":"STOP"}]}`)
+	})
+
+	up := Upstream{Provider: "gemini", Base: srv.URL, Key: geminiTestKey}
+	for _, body := range []string{
+		`{"model":"gemini-2.0-flash","messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"gemini-2.0-flash","messages":[{"role":"user","content":"hi"}],"stream":true}`,
+	} {
+		resp, err := forward(context.Background(), srv.Client(), RouteChat, up, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		closeIO(resp.Body)
+		if gotKey != geminiTestKey {
+			t.Errorf("x-goog-api-key = %q", gotKey)
+		}
+		if strings.Contains(gotQuery, geminiTestKey) {
+			t.Errorf("key 出现在 URL 中: %s", gotQuery)
+		}
+	}
+
+	if _, err := (geminiProvider{}).ListModels(context.Background(), srv.Client(), up); err != nil {
+		t.Fatal(err)
+	}
+	if gotKey != geminiTestKey || strings.Contains(gotQuery, geminiTestKey) {
+		t.Errorf("ListModels 鉴权: header %q, query %q", gotKey, gotQuery)
+	}
+
+	// 连接失败时错误信息中包含完整 URL，不能带上 key
+	up.Base = "http://127.0.0.1:1"
+	_, err := forward(context.Background(), http.DefaultClient, RouteChat, up, []byte(`{"model":"g","messages":[{"role":"user","content":"hi"}],"stream":true}`))
+	if err == nil {
+		t.Fatal("期望连接失败")
+	}
+	if strings.Contains(err.Error(), geminiTestKey) {
+		t.Errorf("错误信息泄露 key: %s", err)
+	}
+}
+
+func TestGeminiCodexUsesFIMTemplates(t *testing.T) {
+	var got []byte
+	srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"candidates":[{"content":{"parts":[{"text":"x"}]},"finishReason":"STOP"}]}`)
+	})
+
+	request := `{"prompt":"def f(","suffix":")","stop":["\n\n"]}`
+	tests := []struct {
+		name       string
+		templates  []FIMTemplate
+		wantPrompt string
+		wantStop   string
+	}{
+		{
+			name:       "未匹配时使用默认指令",
+			wantPrompt: strings.NewReplacer("{prefix}", "def f(", "{suffix}", ")").Replace(geminiFIMTemplate),
+			wantStop:   `["\n\n"]`,
+		},
+		{
+			name:       "匹配配置的 fim_templates",
+			templates:  []FIMTemplate{{Model: "gemini-*", Template: "<prefix>{{.Prefix}}<suffix>{{.Suffix}}<middle>", Stop: []string{"<end>"}, Chat: true}},
+			wantPrompt: "<prefix>def f(<suffix>)<middle>",
+			wantStop:   `["\n\n","<end>"]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.CodexProvider = "gemini"
+			cfg.CodeInstructModel = "gemini-2.0-flash"
+			cfg.FIMTemplates = tt.templates
+			if route := codexRoute(&cfg); route != RouteCodex {
+				t.Fatalf("route = %s", route)
+			}
+
+			body := ConstructRequestBody([]byte(request), &cfg)
+			resp, err := forward(context.Background(), srv.Client(), RouteCodex, Upstream{Provider: "gemini", Base: srv.URL}, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			closeIO(resp.Body)
+
+			if prompt := gjson.GetBytes(got, "contents.0.parts.0.text").String(); prompt != tt.wantPrompt {
+				t.Errorf("prompt = %q，期望 %q", prompt, tt.wantPrompt)
+			}
+			assertJSONEqual(t, []byte(gjson.GetBytes(got, "generationConfig.stopSequences").Raw), tt.wantStop)
+		})
+	}
+}
+
+func TestGeminiRequestBody(t *testing.T) {
+	got, base := fixedUpstream(t, http.StatusOK, "application/json", `{}`)
+
+	forwardBody(t, RouteChat, Upstream{Provider: "gemini", Base: base}, `{
+		"model": "gemini-2.0-flash",
+		"max_tokens": 256,
+		"temperature": 0.7,
+		"top_p": 0.9,
+		"stop": "END",
+		"messages": [
+			{"role": "system", "content": "You are helpful."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "Weather?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBOR"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_a", "content": "sunny"}
+		],
+		"tools": [{"type": "function", "function": {"name": "get_weather", "description": "Get weather", "parameters": {"type": "object"}}}]
+	}`)
+
+	if got.path != "/models/gemini-2.0-flash:generateContent" || got.query != "" {
+		t.Errorf("url = %s?%s", got.path, got.query)
+	}
+	// functionResponse 需要函数名，根据 tool_call_id 找回
+	assertJSONEqual(t, got.body, `{
+		"systemInstruction": {"parts": [{"text": "You are helpful."}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "Weather?"}, {"inlineData": {"mimeType": "image/png", "data": "iVBOR"}}]},
+			{"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"name": "get_weather", "response": {"content": "sunny"}}}]}
+		],
+		"generationConfig": {"maxOutputTokens": 256, "temperature": 0.7, "topP": 0.9, "stopSequences": ["END"]},
+		"tools": [{"functionDeclarations": [{"name": "get_weather", "description": "Get weather", "parameters": {"type": "object"}}]}]
+	}`)
+
+	forwardBody(t, RouteEmbeddings, Upstream{Provider: "gemini", Base: base}, `{"model":"text-embedding-004","input":["a","b"],"dimensions":64}`)
+	if got.path != "/models/text-embedding-004:batchEmbedContents" {
+		t.Errorf("path = %s", got.path)
+	}
+	assertJSONEqual(t, got.body, `{"requests": [
+		{"model": "models/text-embedding-004", "content": {"parts": [{"text": "a"}]}, "outputDimensionality": 64},
+		{"model": "models/text-embedding-004", "content": {"parts": [{"text": "b"}]}, "outputDimensionality": 64}
+	]}`)
+}
+
+func TestGeminiResponse(t *testing.T) {
+	_, base := fixedUpstream(t, http.StatusOK, "application/json", `{
+		"candidates": [{"content": {"role": "model", "parts": [{"text": "It is "}, {"text": "sunny."}]}, "finishReason": "MAX_TOKENS"}],
+		"usageMetadata": {"promptTokenCount": 8, "candidatesTokenCount": 3}
+	}`)
+
+	_, out := forwardBody(t, RouteChat, Upstream{Provider: "gemini", Base: base}, `{"model":"gemini-2.0-flash","messages":[{"role":"user","content":"hi"}]}`)
+	resp := gjson.ParseBytes(out)
+	if resp.Get("model").String() != "gemini-2.0-flash" {
+		t.Errorf("model = %s，期望从 URL 中取出", resp.Get("model"))
+	}
+	assertJSONEqual(t, []byte(resp.Get("choices").Raw), `[{"index": 0, "message": {"role": "assistant", "content": "It is sunny."}, "finish_reason": "length"}]`)
+	assertJSONEqual(t, []byte(resp.Get("usage").Raw), `{"prompt_tokens": 8, "completion_tokens": 3, "total_tokens": 11}`)
+}
+
+func TestGeminiStream(t *testing.T) {
+	tests := []struct {
+		name  string
+		route Route
+		sse   string
+		want  string
+	}{
+		{
+			name:  "chat 文本和函数调用",
+			route: RouteChat,
+			sse: `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Let me "}]}}]}` + "\r\n\r\n" +
+				`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"check."}]}}]}` + "\r\n\r\n" +
+				`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}]}` + "\r\n\r\n",
+			want: `[
+				{"delta": {"role": "assistant", "content": ""}, "finish_reason": null},
+				{"delta": {"content": "Let me "}, "finish_reason": null},
+				{"delta": {"content": "check."}, "finish_reason": null},
+				{"delta": {"tool_calls": [{"index": 0, "id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}, "finish_reason": null},
+				{"delta": {}, "finish_reason": "tool_calls"}
+			]`,
+		},
+		{
+			name:  "chat 内容过滤",
+			route: RouteChat,
+			sse: `data: {"candidates":[{"content":{"parts":[{"text":"Hi"}]}}]}` + "\n\n" +
+				`data: {"candidates":[{"finishReason":"SAFETY"}]}` + "\n\n",
+			want: `[
+				{"delta": {"role": "assistant", "content": ""}, "finish_reason": null},
+				{"delta": {"content": "Hi"}, "finish_reason": null},
+				{"delta": {}, "finish_reason": "content_filter"}
+			]`,
+		},
+		{
+			name:  "chat 流中的错误",
+			route: RouteChat,
+			sse:   `data: {"error":{"code":503,"message":"The model is overloaded.","status":"UNAVAILABLE"}}` + "\n\n",
+			want: `[
+				{"delta": {"role": "assistant", "content": ""}, "finish_reason": null},
+				` + string(openAIError("The model is overloaded.", "UNAVAILABLE")) + `
+			]`,
+		},
+		{
+			name:  "代码补全",
+			route: RouteCodex,
+			sse: `data: {"candidates":[{"content":{"parts":[{"text":"x"}]}}]}` + "\n\n" +
+				`data: {"candidates":[{"content":{"parts":[{"text":")"}]},"finishReason":"STOP"}]}` + "\n\n",
+			want: `[
+				{"text": "x", "finish_reason": null},
+				{"text": ")", "finish_reason": "stop"}
+			]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, base := fixedUpstream(t, http.StatusOK, "text/event-stream", tt.sse)
+			_, out := forwardBody(t, tt.route, Upstream{Provider: "gemini", Base: base},
+				`{"model":"gemini-2.0-flash","stream":true,"messages":[{"role":"user","content":"hi"}],"prompt":"f(","suffix":""}`)
+
+			if got.path != "/models/gemini-2.0-flash:streamGenerateContent" || got.query != "alt=sse" {
+				t.Errorf("url = %s?%s", got.path, got.query)
+			}
+			if model := gjson.Get(firstData(t, out), "model").String(); model != "gemini-2.0-flash" {
+				t.Errorf("model = %s，期望从 URL 中取出", model)
+			}
+			assertJSONEqual(t, []byte(streamChoices(t, out)), tt.want)
+		})
+	}
+}
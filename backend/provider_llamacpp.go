@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func init() {
+	RegisterProvider(llamaCppProvider{})
+}
+
+// llamaCppProvider llama.cpp server，api base 形如 http://127.0.0.1:8080。
+// 代码补全使用 /infill，chat 和 embeddings 使用其 OpenAI 兼容接口 /v1/chat/completions、/v1/embeddings。
+type llamaCppProvider struct{}
+
+func (llamaCppProvider) Name() string {
+	return "llamacpp"
+}
+
+func (llamaCppProvider) NewRequest(ctx context.Context, route Route, up Upstream, body []byte) (*http.Request, error) {
+	if route != RouteCodex {
+		up.Base += "/v1"
+		return openAIProvider{}.NewRequest(ctx, route, up, body)
+	}
+
+	payload, err := llamaCppInfillBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.Base+"/infill", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if up.Key != "" {
+		// 对应 llama-server 的 --api-key 参数
+		req.Header.Set("Authorization", "Bearer "+up.Key)
+	}
+	return req, nil
+}
+
+func (llamaCppProvider) TranslateResponse(route Route, resp *http.Response) (*http.Response, error) {
+	if route != RouteCodex {
+		return resp, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+			message := gjson.GetBytes(body, "error.message").String()
+			if message == "" {
+				message = string(body)
+			}
+			return openAIError(message, gjson.GetBytes(body, "error.type").String()), nil
+		})
+	}
+
+	if isEventStream(resp) {
+		return pipeResponse(resp, "text/event-stream", translateInfillStream), nil
+	}
+	return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+		data := gjson.ParseBytes(body)
+		return json.Marshal(textCompletion(fmt.Sprintf("cmpl-%d", time.Now().UnixNano()), data.Get("model").String(), time.Now().Unix(),
+			data.Get("content").String(), infillFinishReason(data)))
+	})
+}
+
+// ListModels 通过 OpenAI 兼容的 /v1/models 获取已加载的模型
+func (llamaCppProvider) ListModels(ctx context.Context, client *http.Client, up Upstream) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, up.Base+"/v1/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	if up.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+up.Key)
+	}
+
+	return listOpenAIModels(client, req)
+}
+
+// llamaCppInfillBody 将代码补全请求转换为 /infill 请求体。
+// 请求中的 input_extra（[{"filename": "...", "text": "..."}]）会原样传给上游，作为补全时参考的其他文件内容。
+func llamaCppInfillBody(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+	out := map[string]interface{}{
+		"input_prefix": req.Get("prompt").String(),
+		"input_suffix": req.Get("suffix").String(),
+		"stream":       req.Get("stream").Bool(),
+	}
+	if extra := req.Get("input_extra"); extra.IsArray() {
+		out["input_extra"] = json.RawMessage(extra.Raw)
+	}
+	if maxTokens := req.Get("max_tokens").Int(); maxTokens > 0 {
+		out["n_predict"] = maxTokens
+	}
+	for _, key := range []string{"temperature", "top_p", "seed"} {
+		if v := req.Get(key); v.Exists() && v.Type != gjson.Null {
+			out[key] = v.Value()
+		}
+	}
+	if stop := req.Get("stop"); stop.Exists() && stop.Type != gjson.Null {
+		if stop.IsArray() {
+			out["stop"] = json.RawMessage(stop.Raw)
+		} else {
+			out["stop"] = []string{stop.String()}
+		}
+	}
+	return json.Marshal(out)
+}
+
+// infillFinishReason stop 为 true 时结束，stopped_limit 表示达到 n_predict
+func infillFinishReason(data gjson.Result) string {
+	if !data.Get("stop").Bool() {
+		return ""
+	}
+	if data.Get("stopped_limit").Bool() {
+		return "length"
+	}
+	return "stop"
+}
+
+// translateInfillStream 将 /infill 的 content 事件转换为 text_completion 的 SSE
+func translateInfillStream(src io.Reader, dst io.Writer) error {
+	var completions *completionWriter
+
+	err := readSSE(src, func(ev sseEvent) error {
+		data := gjson.ParseBytes(ev.Data)
+		if completions == nil {
+			completions = newCompletionWriter(dst, data.Get("model").String())
+		}
+		if data.Get("error").Exists() {
+			return completions.writeRaw(json.RawMessage(openAIError(data.Get("error.message").String(), data.Get("error.type").String())))
+		}
+
+		text, reason := data.Get("content").String(), infillFinishReason(data)
+		if text == "" && reason == "" {
+			return nil
+		}
+		return completions.write(text, reason)
+	})
+	if err != nil {
+		return err
+	}
+	if completions == nil {
+		completions = newCompletionWriter(dst, "")
+	}
+	return completions.done()
+}
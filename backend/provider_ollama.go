@@ -0,0 +1,378 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func init() {
+	RegisterProvider(ollamaProvider{})
+}
+
+// ollamaProvider Ollama 原生接口，api base 形如 http://127.0.0.1:11434。
+// chat 使用 /api/chat，代码补全使用支持 suffix 的 /api/generate，embeddings 使用 /api/embed，流式响应为 NDJSON。
+type ollamaProvider struct{}
+
+func (ollamaProvider) Name() string {
+	return "ollama"
+}
+
+func (ollamaProvider) NewRequest(ctx context.Context, route Route, up Upstream, body []byte) (*http.Request, error) {
+	path := "/api/chat"
+	var payload []byte
+	var err error
+	switch route {
+	case RouteCodex:
+		path = "/api/generate"
+		payload, err = ollamaGenerateBody(body)
+	case RouteEmbeddings:
+		path = "/api/embed"
+		payload, err = ollamaEmbedBody(body)
+	default:
+		payload, err = ollamaChatBody(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.Base+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if up.Key != "" {
+		// 本地 Ollama 不需要鉴权，经过反向代理时可能需要
+		req.Header.Set("Authorization", "Bearer "+up.Key)
+	}
+	return req, nil
+}
+
+func (ollamaProvider) TranslateResponse(route Route, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != http.StatusOK {
+		return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+			message := gjson.GetBytes(body, "error").String()
+			if message == "" {
+				message = string(body)
+			}
+			return openAIError(message, "ollama_error"), nil
+		})
+	}
+
+	if route == RouteEmbeddings {
+		return replaceJSONBody(resp, translateOllamaEmbed)
+	}
+
+	stream := strings.Contains(resp.Header.Get("Content-Type"), "ndjson")
+	if route == RouteCodex {
+		if stream {
+			return pipeResponse(resp, "text/event-stream", translateOllamaGenerateStream), nil
+		}
+		return replaceJSONBody(resp, func(body []byte) ([]byte, error) {
+			data := gjson.ParseBytes(body)
+			return json.Marshal(textCompletion(fmt.Sprintf("cmpl-%d", time.Now().UnixNano()), data.Get("model").String(), time.Now().Unix(),
+				data.Get("response").String(), ollamaFinishReason(data)))
+		})
+	}
+
+	if stream {
+		return pipeResponse(resp, "text/event-stream", translateOllamaChatStream), nil
+	}
+	return replaceJSONBody(resp, translateOllamaChat)
+}
+
+// ListModels 通过 /api/tags 获取本地已下载的模型
+func (ollamaProvider) ListModels(ctx context.Context, client *http.Client, up Upstream) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, up.Base+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := fetchJSON(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, model := range gjson.GetBytes(body, "models").Array() {
+		names = append(names, model.Get("name").String())
+	}
+	return names, nil
+}
+
+// ollamaOptions 将 OpenAI 的采样参数转换为 Ollama options
+func ollamaOptions(req gjson.Result) map[string]interface{} {
+	options := map[string]interface{}{}
+	if maxTokens := req.Get("max_tokens").Int(); maxTokens > 0 {
+		options["num_predict"] = maxTokens
+	}
+	for _, key := range []string{"temperature", "top_p", "seed"} {
+		if v := req.Get(key); v.Exists() && v.Type != gjson.Null {
+			options[key] = v.Value()
+		}
+	}
+	if stop := req.Get("stop"); stop.Exists() && stop.Type != gjson.Null {
+		var sequences []string
+		if stop.IsArray() {
+			for _, s := range stop.Array() {
+				sequences = append(sequences, s.String())
+			}
+		} else {
+			sequences = []string{stop.String()}
+		}
+		options["stop"] = sequences
+	}
+	return options
+}
+
+// ollamaChatBody 将 OpenAI chat/completions 请求体转换为 /api/chat 请求体
+func ollamaChatBody(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+
+	var messages []map[string]interface{}
+	for _, msg := range req.Get("messages").Array() {
+		role := msg.Get("role").String()
+		if role == "function" {
+			role = "tool"
+		}
+		if role == "developer" {
+			role = "system"
+		}
+		out := map[string]interface{}{
+			"role":    role,
+			"content": messageText(msg.Get("content")),
+		}
+
+		var images []string
+		for _, part := range msg.Get("content").Array() {
+			if _, data, ok := parseDataURL(part.Get("image_url.url").String()); ok {
+				images = append(images, data)
+			}
+		}
+		if len(images) > 0 {
+			out["images"] = images
+		}
+
+		var calls []interface{}
+		for _, call := range msg.Get("tool_calls").Array() {
+			calls = append(calls, map[string]interface{}{
+				"function": map[string]interface{}{
+					"name":      call.Get("function.name").String(),
+					"arguments": toolArguments(call.Get("function.arguments").String()),
+				},
+			})
+		}
+		if len(calls) > 0 {
+			out["tool_calls"] = calls
+		}
+		messages = append(messages, out)
+	}
+
+	// Ollama 不传 stream 时默认流式输出，需要显式指定
+	out := map[string]interface{}{
+		"model":    req.Get("model").String(),
+		"messages": messages,
+		"stream":   req.Get("stream").Bool(),
+	}
+	if options := ollamaOptions(req); len(options) > 0 {
+		out["options"] = options
+	}
+	if tools := req.Get("tools"); tools.IsArray() && len(tools.Array()) > 0 {
+		out["tools"] = json.RawMessage(tools.Raw)
+	}
+	return json.Marshal(out)
+}
+
+// ollamaGenerateBody 将代码补全请求转换为 /api/generate 请求体，suffix 由 Ollama 按模型模板拼成 FIM 提示
+func ollamaGenerateBody(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+	out := map[string]interface{}{
+		"model":  req.Get("model").String(),
+		"prompt": req.Get("prompt").String(),
+		"stream": req.Get("stream").Bool(),
+	}
+	if suffix := req.Get("suffix").String(); suffix != "" {
+		out["suffix"] = suffix
+	}
+	if options := ollamaOptions(req); len(options) > 0 {
+		out["options"] = options
+	}
+	return json.Marshal(out)
+}
+
+// ollamaEmbedBody 将 embeddings 请求体转换为 /api/embed 请求体
+func ollamaEmbedBody(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+	inputs, err := embeddingTexts(req.Get("input"))
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{
+		"model": req.Get("model").String(),
+		"input": inputs,
+	}
+	if dimensions := req.Get("dimensions").Int(); dimensions > 0 {
+		out["dimensions"] = dimensions
+	}
+	return json.Marshal(out)
+}
+
+// translateOllamaEmbed 将 /api/embed 响应转换为 OpenAI embeddings 格式
+func translateOllamaEmbed(body []byte) ([]byte, error) {
+	data := gjson.ParseBytes(body)
+	var vectors []json.RawMessage
+	for _, vector := range data.Get("embeddings").Array() {
+		vectors = append(vectors, json.RawMessage(vector.Raw))
+	}
+	return embeddingList(data.Get("model").String(), vectors, data.Get("prompt_eval_count").Int())
+}
+
+// ollamaFinishReason 最后一行 done 为 true，done_reason 为 length 时表示达到 num_predict
+func ollamaFinishReason(data gjson.Result) string {
+	if !data.Get("done").Bool() {
+		return ""
+	}
+	if data.Get("done_reason").String() == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// ollamaToolCalls 转换 message.tool_calls，Ollama 不返回调用 id，按顺序生成
+func ollamaToolCalls(message gjson.Result, offset int) []interface{} {
+	var calls []interface{}
+	for _, call := range message.Get("tool_calls").Array() {
+		args := call.Get("function.arguments").Raw
+		if args == "" {
+			args = "{}"
+		}
+		index := offset + len(calls)
+		calls = append(calls, map[string]interface{}{
+			"index": index,
+			"id":    fmt.Sprintf("call_%d", index),
+			"type":  "function",
+			"function": map[string]interface{}{
+				"name":      call.Get("function.name").String(),
+				"arguments": args,
+			},
+		})
+	}
+	return calls
+}
+
+// translateOllamaChat 将非流式的 /api/chat 响应转换为 chat.completion
+func translateOllamaChat(body []byte) ([]byte, error) {
+	data := gjson.ParseBytes(body)
+	reason := ollamaFinishReason(data)
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": data.Get("message.content").String(),
+	}
+	if calls := ollamaToolCalls(data.Get("message"), 0); len(calls) > 0 {
+		message["tool_calls"] = calls
+		reason = "tool_calls"
+	}
+
+	promptTokens := data.Get("prompt_eval_count").Int()
+	completionTokens := data.Get("eval_count").Int()
+	return json.Marshal(map[string]interface{}{
+		"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   data.Get("model").String(),
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"message":       message,
+			"finish_reason": reason,
+		}},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens,
+			"total_tokens":      promptTokens + completionTokens,
+		},
+	})
+}
+
+// translateOllamaChatStream 将 /api/chat 的 NDJSON 转换为 chat.completion.chunk 的 SSE
+func translateOllamaChatStream(src io.Reader, dst io.Writer) error {
+	var chunks *chunkWriter
+	toolCalls := 0
+
+	err := readLines(src, func(line []byte) error {
+		data := gjson.ParseBytes(line)
+		if data.Get("error").Exists() {
+			if chunks == nil {
+				chunks = newChunkWriter(dst, "")
+			}
+			return chunks.writeRaw(json.RawMessage(openAIError(data.Get("error").String(), "ollama_error")))
+		}
+		if chunks == nil {
+			chunks = newChunkWriter(dst, data.Get("model").String())
+			if err := chunks.write(map[string]interface{}{"role": "assistant", "content": ""}, ""); err != nil {
+				return err
+			}
+		}
+
+		if content := data.Get("message.content").String(); content != "" {
+			if err := chunks.write(map[string]interface{}{"content": content}, ""); err != nil {
+				return err
+			}
+		}
+		if calls := ollamaToolCalls(data.Get("message"), toolCalls); len(calls) > 0 {
+			toolCalls += len(calls)
+			if err := chunks.write(map[string]interface{}{"tool_calls": calls}, ""); err != nil {
+				return err
+			}
+		}
+		if reason := ollamaFinishReason(data); reason != "" {
+			if toolCalls > 0 {
+				reason = "tool_calls"
+			}
+			return chunks.write(map[string]interface{}{}, reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if chunks == nil {
+		chunks = newChunkWriter(dst, "")
+	}
+	return chunks.done()
+}
+
+// translateOllamaGenerateStream 将 /api/generate 的 NDJSON 转换为 text_completion 的 SSE
+func translateOllamaGenerateStream(src io.Reader, dst io.Writer) error {
+	var completions *completionWriter
+
+	err := readLines(src, func(line []byte) error {
+		data := gjson.ParseBytes(line)
+		if completions == nil {
+			completions = newCompletionWriter(dst, data.Get("model").String())
+		}
+		if data.Get("error").Exists() {
+			return completions.writeRaw(json.RawMessage(openAIError(data.Get("error").String(), "ollama_error")))
+		}
+
+		text, reason := data.Get("response").String(), ollamaFinishReason(data)
+		if text == "" && reason == "" {
+			return nil
+		}
+		return completions.write(text, reason)
+	})
+	if err != nil {
+		return err
+	}
+	if completions == nil {
+		completions = newCompletionWriter(dst, "")
+	}
+	return completions.done()
+}
@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestOllamaRequestBody(t *testing.T) {
+	got, base := fixedUpstream(t, http.StatusOK, "application/json", `{}`)
+	up := Upstream{Provider: "ollama", Base: base}
+
+	forwardBody(t, RouteChat, up, `{
+		"model": "llama3",
+		"max_tokens": 128,
+		"temperature": 0,
+		"stop": ["END"],
+		"messages": [
+			{"role": "developer", "content": "Be brief."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "Describe"},
+				{"type": "image_url", "image_url": {"url": "data:image/jpeg;base64,/9j/4A"}}
+			]},
+			{"role": "assistant", "content": "", "tool_calls": [
+				{"id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+			]},
+			{"role": "function", "name": "get_weather", "content": "sunny"}
+		],
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}]
+	}`)
+	if got.path != "/api/chat" || got.header.Get("Authorization") != "" {
+		t.Errorf("path = %s，Authorization %q", got.path, got.header.Get("Authorization"))
+	}
+	// 未指定 stream 时必须显式传 false，否则 Ollama 默认流式输出
+	assertJSONEqual(t, got.body, `{
+		"model": "llama3",
+		"stream": false,
+		"options": {"num_predict": 128, "temperature": 0, "stop": ["END"]},
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": "Describe", "images": ["/9j/4A"]},
+			{"role": "assistant", "content": "", "tool_calls": [{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}}]},
+			{"role": "tool", "content": "sunny"}
+		],
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}]
+	}`)
+
+	up.Key = "proxy-key"
+	forwardBody(t, RouteCodex, up, `{"model":"qwen2.5-coder","prompt":"def f(","suffix":")","stream":true,"seed":1}`)
+	if got.path != "/api/generate" || got.header.Get("Authorization") != "Bearer proxy-key" {
+		t.Errorf("path = %s，Authorization %q", got.path, got.header.Get("Authorization"))
+	}
+	assertJSONEqual(t, got.body, `{"model":"qwen2.5-coder","prompt":"def f(","suffix":")","stream":true,"options":{"seed":1}}`)
+
+	forwardBody(t, RouteEmbeddings, up, `{"model":"nomic-embed-text","input":"hello","dimensions":256}`)
+	if got.path != "/api/embed" {
+		t.Errorf("path = %s", got.path)
+	}
+	assertJSONEqual(t, got.body, `{"model":"nomic-embed-text","input":["hello"],"dimensions":256}`)
+}
+
+func TestOllamaChatResponse(t *testing.T) {
+	_, base := fixedUpstream(t, http.StatusOK, "application/json", `{
+		"model": "llama3",
+		"message": {"role": "assistant", "content": "", "tool_calls": [
+			{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}},
+			{"function": {"name": "get_time"}}
+		]},
+		"done": true,
+		"done_reason": "stop",
+		"prompt_eval_count": 20,
+		"eval_count": 7
+	}`)
+
+	_, out := forwardBody(t, RouteChat, Upstream{Provider: "ollama", Base: base}, `{"messages":[{"role":"user","content":"hi"}]}`)
+	resp := gjson.ParseBytes(out)
+	if resp.Get("object").String() != "chat.completion" || resp.Get("model").String() != "llama3" {
+		t.Errorf("响应头部字段: %s", out)
+	}
+	// Ollama 不返回调用 id，按顺序生成；有函数调用时 finish_reason 为 tool_calls
+	assertJSONEqual(t, []byte(resp.Get("choices").Raw), `[{
+		"index": 0,
+		"message": {"role": "assistant", "content": "", "tool_calls": [
+			{"index": 0, "id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}},
+			{"index": 1, "id": "call_1", "type": "function", "function": {"name": "get_time", "arguments": "{}"}}
+		]},
+		"finish_reason": "tool_calls"
+	}]`)
+	assertJSONEqual(t, []byte(resp.Get("usage").Raw), `{"prompt_tokens": 20, "completion_tokens": 7, "total_tokens": 27}`)
+}
+
+func TestOllamaChatStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		ndjson string
+		want   string
+	}{
+		{
+			name: "文本和 done_reason length",
+			ndjson: `{"model":"llama3","message":{"role":"assistant","content":"Hel"},"done":false}` + "\n" +
+				"\n" +
+				`{"model":"llama3","message":{"role":"assistant","content":"lo"},"done":false}` + "\n" +
+				`{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"done_reason":"length"}` + "\n",
+			want: `[
+				{"delta": {"role": "assistant", "content": ""}, "finish_reason": null},
+				{"delta": {"content": "Hel"}, "finish_reason": null},
+				{"delta": {"content": "lo"}, "finish_reason": null},
+				{"delta": {}, "finish_reason": "length"}
+			]`,
+		},
+		{
+			name: "tool_calls 分多行返回",
+			ndjson: `{"model":"llama3","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":false}` + "\n" +
+				`{"model":"llama3","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_time","arguments":{}}}]},"done":false}` + "\n" +
+				`{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`,
+			want: `[
+				{"delta": {"role": "assistant", "content": ""}, "finish_reason": null},
+				{"delta": {"tool_calls": [{"index": 0, "id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}, "finish_reason": null},
+				{"delta": {"tool_calls": [{"index": 1, "id": "call_1", "type": "function", "function": {"name": "get_time", "arguments": "{}"}}]}, "finish_reason": null},
+				{"delta": {}, "finish_reason": "tool_calls"}
+			]`,
+		},
+		{
+			name: "流中的错误",
+			ndjson: `{"model":"llama3","message":{"role":"assistant","content":"Hi"},"done":false}` + "\n" +
+				`{"error":"an error was encountered while running the model"}` + "\n",
+			want: `[
+				{"delta": {"role": "assistant", "content": ""}, "finish_reason": null},
+				{"delta": {"content": "Hi"}, "finish_reason": null},
+				` + string(openAIError("an error was encountered while running the model", "ollama_error")) + `
+			]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, base := fixedUpstream(t, http.StatusOK, "application/x-ndjson", tt.ndjson)
+			status, out := forwardBody(t, RouteChat, Upstream{Provider: "ollama", Base: base}, `{"messages":[{"role":"user","content":"hi"}],"stream":true}`)
+			if status != http.StatusOK {
+				t.Errorf("状态码 = %d", status)
+			}
+			assertJSONEqual(t, []byte(streamChoices(t, out)), tt.want)
+		})
+	}
+}
+
+func TestOllamaGenerateStream(t *testing.T) {
+	ndjson := `{"model":"qwen2.5-coder","response":"x","done":false}` + "\n" +
+		`{"model":"qwen2.5-coder","response":"","done":false}` + "\n" +
+		`{"model":"qwen2.5-coder","response":")","done":true,"done_reason":"stop"}` + "\n"
+	_, base := fixedUpstream(t, http.StatusOK, "application/x-ndjson", ndjson)
+
+	_, out := forwardBody(t, RouteCodex, Upstream{Provider: "ollama", Base: base}, `{"prompt":"f(","suffix":"","stream":true}`)
+	if object := gjson.Get(firstData(t, out), "object").String(); object != "text_completion" {
+		t.Errorf("object = %s", object)
+	}
+	assertJSONEqual(t, []byte(streamChoices(t, out)), `[
+		{"text": "x", "finish_reason": null},
+		{"text": ")", "finish_reason": "stop"}
+	]`)
+}
+
+func TestOllamaEmbedResponse(t *testing.T) {
+	_, base := fixedUpstream(t, http.StatusOK, "application/json", `{"model":"nomic-embed-text","embeddings":[[0.1,0.2],[0.3,0.4]],"prompt_eval_count":4}`)
+
+	_, out := forwardBody(t, RouteEmbeddings, Upstream{Provider: "ollama", Base: base}, `{"model":"nomic-embed-text","input":["a","b"]}`)
+	assertJSONEqual(t, out, `{
+		"object": "list",
+		"model": "nomic-embed-text",
+		"data": [
+			{"object": "embedding", "index": 0, "embedding": [0.1, 0.2]},
+			{"object": "embedding", "index": 1, "embedding": [0.3, 0.4]}
+		],
+		"usage": {"prompt_tokens": 4, "total_tokens": 4}
+	}`)
+}
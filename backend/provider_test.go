@@ -0,0 +1,306 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// upstreamRequest 测试上游收到的请求
+type upstreamRequest struct {
+	path   string
+	query  string
+	header http.Header
+	body   []byte
+}
+
+// fixedUpstream 启动返回固定响应的测试上游，记录最后一次收到的请求
+func fixedUpstream(t *testing.T, status int, contentType, body string) (*upstreamRequest, string) {
+	t.Helper()
+	got := &upstreamRequest{}
+	srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		got.path, got.query, got.header = r.URL.Path, r.URL.RawQuery, r.Header.Clone()
+		got.body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	})
+	return got, srv.URL
+}
+
+// forwardBody 通过 forward 请求上游，返回转换后的状态码和完整响应体
+func forwardBody(t *testing.T, route Route, up Upstream, body string) (int, []byte) {
+	t.Helper()
+	resp, err := forward(context.Background(), http.DefaultClient, route, up, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeIO(resp.Body)
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, out
+}
+
+// streamChoices 解析转换后的 SSE，返回每个事件的 choices.0（去掉 index 和 logprobs），错误事件原样返回。
+// 同时检查流以 [DONE] 结束，所有 chunk 使用同一个 id。
+func streamChoices(t *testing.T, stream []byte) string {
+	t.Helper()
+	var events []json.RawMessage
+	done := false
+	ids := map[string]bool{}
+	err := readSSE(bytes.NewReader(stream), func(ev sseEvent) error {
+		if done {
+			t.Errorf("[DONE] 之后还有事件: %s", ev.Data)
+		}
+		if string(ev.Data) == "[DONE]" {
+			done = true
+			return nil
+		}
+		data := gjson.ParseBytes(ev.Data)
+		if data.Get("error").Exists() {
+			events = append(events, json.RawMessage(ev.Data))
+			return nil
+		}
+		ids[data.Get("id").String()] = true
+		choice := []byte(data.Get("choices.0").Raw)
+		choice, _ = sjson.DeleteBytes(choice, "index")
+		choice, _ = sjson.DeleteBytes(choice, "logprobs")
+		events = append(events, choice)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done {
+		t.Errorf("流没有以 [DONE] 结束:\n%s", stream)
+	}
+	if len(ids) > 1 {
+		t.Errorf("chunk id 不一致: %v", ids)
+	}
+
+	out, _ := json.Marshal(events)
+	return string(out)
+}
+
+// firstData 返回 SSE 中第一个事件的 data
+func firstData(t *testing.T, stream []byte) string {
+	t.Helper()
+	for _, line := range strings.Split(string(stream), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			return data
+		}
+	}
+	t.Fatalf("没有 data 事件: %s", stream)
+	return ""
+}
+
+func TestOpenAIRequest(t *testing.T) {
+	tests := []struct {
+		route    Route
+		wantPath string
+	}{
+		{RouteChat, "/v1/chat/completions"},
+		{RouteCodex, "/v1/completions"},
+		{RouteEmbeddings, "/v1/embeddings"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.route), func(t *testing.T) {
+			got, base := fixedUpstream(t, http.StatusOK, "application/json", `{"id":"x"}`)
+			up := Upstream{Base: base + "/v1", Key: "sk-test", Organization: "org-1", Project: "proj-1"}
+			body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+			status, out := forwardBody(t, tt.route, up, body)
+			if status != http.StatusOK || string(out) != `{"id":"x"}` {
+				t.Errorf("响应 = %d %s，期望原样返回", status, out)
+			}
+			if got.path != tt.wantPath {
+				t.Errorf("path = %s，期望 %s", got.path, tt.wantPath)
+			}
+			if string(got.body) != body {
+				t.Errorf("请求体被修改: %s", got.body)
+			}
+			for name, want := range map[string]string{
+				"Authorization":       "Bearer sk-test",
+				"OpenAI-Organization": "org-1",
+				"OpenAI-Project":      "proj-1",
+			} {
+				if v := got.header.Get(name); v != want {
+					t.Errorf("%s = %q，期望 %q", name, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAzureRequest(t *testing.T) {
+	got, base := fixedUpstream(t, http.StatusOK, "application/json", `{}`)
+
+	forwardBody(t, RouteChat, Upstream{Provider: "azure", Base: base + "/", Key: "az-key"}, `{"model":"gpt 4o","messages":[]}`)
+	if got.path != "/openai/deployments/gpt 4o/chat/completions" || got.query != "api-version="+azureDefaultAPIVersion {
+		t.Errorf("url = %s?%s", got.path, got.query)
+	}
+	if got.header.Get("api-key") != "az-key" || got.header.Get("Authorization") != "" {
+		t.Errorf("鉴权头: api-key %q, Authorization %q", got.header.Get("api-key"), got.header.Get("Authorization"))
+	}
+
+	forwardBody(t, RouteEmbeddings, Upstream{Provider: "azure", Base: base, APIVersion: "2024-10-21"}, `{"model":"embed","input":["a"]}`)
+	if got.path != "/openai/deployments/embed/embeddings" || got.query != "api-version=2024-10-21" {
+		t.Errorf("url = %s?%s", got.path, got.query)
+	}
+
+	if _, err := (azureProvider{}).NewRequest(context.Background(), RouteChat, Upstream{Base: base}, []byte(`{"messages":[]}`)); err == nil {
+		t.Error("缺少部署名时应返回错误")
+	}
+}
+
+func TestLlamaCppRequest(t *testing.T) {
+	got, base := fixedUpstream(t, http.StatusOK, "application/json", `{}`)
+	up := Upstream{Provider: "llamacpp", Base: base, Key: "local-key"}
+
+	forwardBody(t, RouteChat, up, `{"model":"qwen","messages":[]}`)
+	if got.path != "/v1/chat/completions" {
+		t.Errorf("chat path = %s", got.path)
+	}
+
+	forwardBody(t, RouteCodex, up, `{"model":"qwen","prompt":"def f(","suffix":")","max_tokens":64,"temperature":0.2,"stop":"\n\n","input_extra":[{"filename":"a.py","text":"x = 1"}]}`)
+	if got.path != "/infill" || got.header.Get("Authorization") != "Bearer local-key" {
+		t.Errorf("codex 请求 = %s，Authorization %q", got.path, got.header.Get("Authorization"))
+	}
+	assertJSONEqual(t, got.body, `{
+		"input_prefix": "def f(",
+		"input_suffix": ")",
+		"stream": false,
+		"n_predict": 64,
+		"temperature": 0.2,
+		"stop": ["\n\n"],
+		"input_extra": [{"filename": "a.py", "text": "x = 1"}]
+	}`)
+}
+
+func TestLlamaCppInfillResponse(t *testing.T) {
+	t.Run("非流式", func(t *testing.T) {
+		_, base := fixedUpstream(t, http.StatusOK, "application/json", `{"model":"qwen","content":"x)","stop":true,"stopped_limit":true}`)
+		_, out := forwardBody(t, RouteCodex, Upstream{Provider: "llamacpp", Base: base}, `{"prompt":"f(","suffix":")"}`)
+
+		if object := gjson.GetBytes(out, "object").String(); object != "text_completion" {
+			t.Errorf("object = %s", object)
+		}
+		assertJSONEqual(t, []byte(gjson.GetBytes(out, "choices").Raw), `[{"index":0,"text":"x)","logprobs":null,"finish_reason":"length"}]`)
+	})
+
+	t.Run("流式", func(t *testing.T) {
+		stream := "data: {\"model\":\"qwen\",\"content\":\"x\",\"stop\":false}\n\n" +
+			"data: {\"content\":\"y\",\"stop\":false}\n\n" +
+			"data: {\"content\":\"\",\"stop\":true}\n\n"
+		_, base := fixedUpstream(t, http.StatusOK, "text/event-stream", stream)
+		_, out := forwardBody(t, RouteCodex, Upstream{Provider: "llamacpp", Base: base}, `{"prompt":"f(","suffix":")","stream":true}`)
+
+		assertJSONEqual(t, []byte(streamChoices(t, out)), `[
+			{"text":"x","finish_reason":null},
+			{"text":"y","finish_reason":null},
+			{"text":"","finish_reason":"stop"}
+		]`)
+	})
+}
+
+func TestProviderErrorBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		route    Route
+		status   int
+		body     string
+		want     string
+	}{
+		{
+			name:     "anthropic",
+			provider: "anthropic",
+			route:    RouteChat,
+			status:   http.StatusBadRequest,
+			body:     `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: too large"}}`,
+			want:     string(openAIError("max_tokens: too large", "invalid_request_error")),
+		},
+		{
+			name:     "anthropic 非 JSON",
+			provider: "anthropic",
+			route:    RouteChat,
+			status:   http.StatusBadGateway,
+			body:     `upstream connect error`,
+			want:     string(openAIError("upstream connect error", "")),
+		},
+		{
+			name:     "gemini",
+			provider: "gemini",
+			route:    RouteChat,
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"code":400,"message":"API key not valid","status":"INVALID_ARGUMENT"}}`,
+			want:     string(openAIError("API key not valid", "INVALID_ARGUMENT")),
+		},
+		{
+			name:     "ollama",
+			provider: "ollama",
+			route:    RouteChat,
+			status:   http.StatusNotFound,
+			body:     `{"error":"model \"llama3\" not found, try pulling it first"}`,
+			want:     string(openAIError(`model "llama3" not found, try pulling it first`, "ollama_error")),
+		},
+		{
+			name:     "llamacpp infill",
+			provider: "llamacpp",
+			route:    RouteCodex,
+			status:   http.StatusServiceUnavailable,
+			body:     `{"error":{"code":503,"message":"Loading model","type":"unavailable_error"}}`,
+			want:     string(openAIError("Loading model", "unavailable_error")),
+		},
+		{
+			name:     "azure",
+			provider: "azure",
+			route:    RouteChat,
+			status:   http.StatusTooManyRequests,
+			body:     `{"error":{"code":"429","message":"Rate limit is exceeded."}}`,
+			want:     string(openAIError("Rate limit is exceeded.", "429")),
+		},
+		{
+			name:     "azure 内容过滤",
+			provider: "azure",
+			route:    RouteChat,
+			status:   http.StatusBadRequest,
+			body: `{"error":{"code":"content_filter","message":"filtered","innererror":{"content_filter_result":{
+				"violence":{"filtered":true,"severity":"medium"},"hate":{"filtered":true,"severity":"high"},"sexual":{"filtered":false}}}}}`,
+			want: string(openAIError("请求被 Azure 内容过滤拦截: hate(high), violence(medium)", "content_filter")),
+		},
+		{
+			name:     "openai 原样返回",
+			provider: "openai",
+			route:    RouteChat,
+			status:   http.StatusUnauthorized,
+			body:     `{"error":{"message":"Incorrect API key","type":"invalid_request_error","code":"invalid_api_key"}}`,
+			want:     `{"error":{"message":"Incorrect API key","type":"invalid_request_error","code":"invalid_api_key"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, base := fixedUpstream(t, tt.status, "application/json", tt.body)
+			status, out := forwardBody(t, tt.route, Upstream{Provider: tt.provider, Base: base}, `{"model":"m","messages":[{"role":"user","content":"hi"}],"prompt":"a","suffix":"b"}`)
+			if status != tt.status {
+				t.Errorf("状态码 = %d，期望保留上游的 %d", status, tt.status)
+			}
+			if !strings.HasPrefix(string(out), "{") {
+				t.Fatalf("响应体不是 JSON: %s", out)
+			}
+			assertJSONEqual(t, out, tt.want)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseKeepaliveInterval 上游超过该时间没有输出时（如推理模型思考中）向客户端写入注释行，避免连接被中间代理断开
+const sseKeepaliveInterval = 15 * time.Second
+
+// relayResponse 把上游响应写给客户端。SSE 流逐行转发并及时 flush，其他响应原样复制。
+// 客户端断开或写入失败时调用 cancel 取消上游请求。
+func relayResponse(c *gin.Context, resp *http.Response, cancel context.CancelFunc) {
+	c.Status(resp.StatusCode)
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+
+	if !isEventStream(resp) {
+		_, _ = io.Copy(c.Writer, resp.Body)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	if err := relaySSE(c.Request.Context(), c.Writer, resp.Body, sseKeepaliveInterval); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Println("客户端已断开，取消上游请求")
+		} else {
+			log.Println("写入客户端失败，取消上游请求:", err)
+		}
+		cancel()
+	}
+}
+
+// relaySSE 逐行转发 SSE 流：每个 data 行和事件结束的空行之后 flush，上游空闲超过 keepalive 时写入注释行。
+// 上游读完时返回 nil；客户端断开或写入失败时返回错误，调用方负责取消上游请求。
+func relaySSE(ctx context.Context, w io.Writer, r io.Reader, keepalive time.Duration) error {
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				select {
+				case lines <- line:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	lastWrite := time.Now()
+	timer := time.NewTimer(keepalive)
+	defer timer.Stop()
+
+	// inEvent 表示已经转发了当前事件的部分字段，此时心跳不能带空行，否则会提前结束该事件
+	inEvent := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-readErr:
+			flush()
+			if err != io.EOF && ctx.Err() == nil {
+				log.Println("读取上游响应失败:", err)
+			}
+			return ctx.Err()
+
+		case <-timer.C:
+			if idle := time.Since(lastWrite); idle < keepalive {
+				// 计时器在转发期间已到期，按最后一次写入时间重新计时
+				timer.Reset(keepalive - idle)
+				continue
+			}
+			keepaliveLine := ": keepalive\n"
+			if !inEvent {
+				keepaliveLine += "\n"
+			}
+			if _, err := io.WriteString(w, keepaliveLine); err != nil {
+				return err
+			}
+			flush()
+			lastWrite = time.Now()
+			timer.Reset(keepalive)
+
+		case line := <-lines:
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			trimmed := bytes.TrimRight(line, "\r\n")
+			switch {
+			case len(trimmed) == 0:
+				inEvent = false
+				flush()
+			case bytes.HasPrefix(trimmed, []byte("data:")):
+				inEvent = true
+				flush()
+			default:
+				inEvent = true
+			}
+			lastWrite = time.Now()
+		}
+	}
+}
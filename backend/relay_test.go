@@ -0,0 +1,211 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flushRecorder 记录每次 flush 时写入的内容和时间
+type flushRecorder struct {
+	buf     bytes.Buffer
+	flushes []flushed
+}
+
+type flushed struct {
+	at   time.Time
+	data string
+}
+
+func (r *flushRecorder) Write(p []byte) (int, error) {
+	return r.buf.Write(p)
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes = append(r.flushes, flushed{at: time.Now(), data: r.buf.String()})
+	r.buf.Reset()
+}
+
+func (r *flushRecorder) output() string {
+	var out strings.Builder
+	for _, f := range r.flushes {
+		out.WriteString(f.data)
+	}
+	return out.String() + r.buf.String()
+}
+
+// sseStep 测试上游等待 wait 后输出 data
+type sseStep struct {
+	wait time.Duration
+	data string
+}
+
+// slowUpstream 按 steps 依次输出 SSE 片段，每段输出后立即 flush 并把时间发送到 sent
+func slowUpstream(t *testing.T, steps []sseStep, sent chan<- time.Time) string {
+	return newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for _, step := range steps {
+			select {
+			case <-time.After(step.wait):
+			case <-r.Context().Done():
+				return
+			}
+			_, _ = io.WriteString(w, step.data)
+			flusher.Flush()
+			if sent != nil {
+				sent <- time.Now()
+			}
+		}
+	}).URL
+}
+
+func relayFrom(t *testing.T, url string, keepalive time.Duration) *flushRecorder {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeIO(resp.Body)
+
+	rec := &flushRecorder{}
+	if err := relaySSE(context.Background(), rec, resp.Body, keepalive); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestRelaySSEFlushesEachEvent(t *testing.T) {
+	steps := []sseStep{
+		{0, "data: {\"i\":0}\n\n"},
+		{150 * time.Millisecond, "data: {\"i\":1}\n\n"},
+		{150 * time.Millisecond, "data: {\"i\":2}\n\n"},
+		{150 * time.Millisecond, "data: [DONE]\n\n"},
+	}
+	sent := make(chan time.Time, len(steps))
+	rec := relayFrom(t, slowUpstream(t, steps, sent), time.Minute)
+	close(sent)
+
+	var flushedData []flushed
+	for _, f := range rec.flushes {
+		if strings.HasPrefix(f.data, "data:") {
+			flushedData = append(flushedData, f)
+		}
+	}
+	if len(flushedData) != len(steps) {
+		t.Fatalf("flush 了 %d 个 data 行，期望 %d: %q", len(flushedData), len(steps), rec.output())
+	}
+	i := 0
+	for sentAt := range sent {
+		f := flushedData[i]
+		if want := strings.TrimSuffix(steps[i].data, "\n"); f.data != want {
+			t.Errorf("第 %d 次 flush = %q，期望 %q", i, f.data, want)
+		}
+		if delay := f.at.Sub(sentAt); delay > 50*time.Millisecond {
+			t.Errorf("第 %d 个事件在上游发出 %s 后才 flush", i, delay)
+		}
+		i++
+	}
+	if rec.output() != strings.Join([]string{steps[0].data, steps[1].data, steps[2].data, steps[3].data}, "") {
+		t.Errorf("转发内容被修改: %q", rec.output())
+	}
+}
+
+func TestRelaySSEKeepaliveWhenIdle(t *testing.T) {
+	steps := []sseStep{
+		{0, "data: {\"i\":0}\n\n"},
+		{300 * time.Millisecond, "data: [DONE]\n\n"},
+	}
+	rec := relayFrom(t, slowUpstream(t, steps, nil), 50*time.Millisecond)
+
+	out := rec.output()
+	if n := strings.Count(out, ": keepalive\n\n"); n < 3 {
+		t.Errorf("空闲 300ms 应写入多次心跳，实际 %d 次: %q", n, out)
+	}
+	if !strings.HasPrefix(out, steps[0].data+": keepalive\n\n") || !strings.HasSuffix(out, steps[1].data) {
+		t.Errorf("心跳位置不正确: %q", out)
+	}
+	if strings.Count(relayFrom(t, slowUpstream(t, steps[:1], nil), time.Minute).output(), "keepalive") != 0 {
+		t.Error("上游持续输出时不应写入心跳")
+	}
+}
+
+func TestRelaySSEKeepaliveInsideEvent(t *testing.T) {
+	steps := []sseStep{
+		{0, "event: message\n"},
+		{200 * time.Millisecond, "data: {\"i\":0}\n\n"},
+	}
+	rec := relayFrom(t, slowUpstream(t, steps, nil), 50*time.Millisecond)
+
+	out := rec.output()
+	if !strings.Contains(out, ": keepalive\n") {
+		t.Fatalf("事件中途空闲时也应写入心跳: %q", out)
+	}
+	if strings.Contains(out, "\n\n: keepalive") || strings.Contains(out, ": keepalive\n\n") {
+		t.Errorf("事件结束前的心跳不能带空行: %q", out)
+	}
+
+	var events []sseEvent
+	if err := readSSE(strings.NewReader(out), func(ev sseEvent) error {
+		events = append(events, ev)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Event != "message" || string(events[0].Data) != `{"i":0}` {
+		t.Errorf("客户端解析到的事件 = %+v，期望一个完整的 message 事件", events)
+	}
+}
+
+func TestRelayCancelsUpstreamOnClientDisconnect(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: {\"choices\":[]}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	})
+
+	s := newTestService(t, upstream.URL, nil)
+	e := gin.New()
+	s.InitRoutes(e)
+	proxy := newUpstream(t, e.ServeHTTP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, proxy.URL+"/v1/chat/completions",
+		strings.NewReader(`{"messages":[{"role":"user","content":"hi"}],"stream":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeIO(resp.Body)
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data:") {
+		t.Fatalf("首个事件 = %q, %v", line, err)
+	}
+
+	cancel()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("客户端断开后上游请求没有被取消")
+	}
+}
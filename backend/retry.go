@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy 单个上游的重试策略，对应 chat_retry、codex_retry
+type RetryPolicy struct {
+	// MaxAttempts 包含首次请求在内的最多尝试次数，小于等于 1 时不重试
+	MaxAttempts int `json:"max_attempts"`
+	// BaseDelayMs 第一次重试前的最大等待时间，之后每次翻倍，实际等待时间在 0 到该值之间随机
+	BaseDelayMs int `json:"base_delay_ms"`
+	// MaxDelayMs 单次等待的上限，上游要求等待更久时不再重试该上游
+	MaxDelayMs int `json:"max_delay_ms"`
+}
+
+// retryable 连接被重置以及 429、502、503、504 可以安全地重试，此时上游还没有开始处理或已拒绝请求
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// delay 第 attempt 次失败后的等待时间：指数退避加随机抖动，上游给出的等待时间更长时以上游为准。
+// 返回 false 表示上游要求的等待时间超过 max_delay_ms。
+func (p RetryPolicy) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	maxDelay := time.Duration(p.MaxDelayMs) * time.Millisecond
+	backoff := time.Duration(p.BaseDelayMs) * time.Millisecond << (attempt - 1)
+	if backoff <= 0 || (maxDelay > 0 && backoff > maxDelay) {
+		backoff = maxDelay
+	}
+
+	var wait time.Duration
+	if backoff > 0 {
+		wait = time.Duration(rand.Int63n(int64(backoff) + 1))
+	}
+	if resp != nil {
+		if hint := retryAfter(resp.Header); hint > wait {
+			if maxDelay > 0 && hint > maxDelay {
+				return 0, false
+			}
+			wait = hint
+		}
+	}
+	return wait, true
+}
+
+// retryAfter 解析上游建议的等待时间，支持 Retry-After（秒或 HTTP 日期）、retry-after-ms 以及
+// OpenAI 的 x-ratelimit-reset-requests、x-ratelimit-reset-tokens（如 "1s"、"6m0s"），取其中最长的
+func retryAfter(header http.Header) time.Duration {
+	var wait time.Duration
+	longer := func(d time.Duration) {
+		if d > wait {
+			wait = d
+		}
+	}
+
+	if v := header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			longer(time.Duration(seconds) * time.Second)
+		} else if t, err := http.ParseTime(v); err == nil {
+			longer(time.Until(t))
+		}
+	}
+	if ms, err := strconv.Atoi(header.Get("Retry-After-Ms")); err == nil {
+		longer(time.Duration(ms) * time.Millisecond)
+	}
+	for _, key := range []string{"X-Ratelimit-Reset-Requests", "X-Ratelimit-Reset-Tokens"} {
+		if d, err := time.ParseDuration(header.Get(key)); err == nil {
+			longer(d)
+		}
+	}
+	return wait
+}
+
+// forwardRetry 按重试策略请求同一个上游。只在拿到响应头、尚未向客户端写入时重试，
+// 等待时间超出请求 context 的截止时间时直接返回最后一次的结果。每次请求的结果记录到负载均衡和熔断器。
+// hasNext 表示之后还有其他上游（或同一上游的其他 key）可以尝试，此时 429 不再等待重试，直接交给下一个上游。
+func (s *ProxyService) forwardRetry(ctx context.Context, snapshot *proxySnapshot, policy RetryPolicy, route Route, up Upstream, body []byte, hasNext bool) (*http.Response, error) {
+	breakerCfg := snapshot.cfg.CircuitBreaker
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		resp, err := forward(ctx, snapshot.client, route, up, body)
+		switch {
+		case err == nil:
+			s.balancer.observe(up, time.Since(start), resp.StatusCode)
+			s.breakers.record(breakerCfg, up, time.Since(start), resp.StatusCode)
+		case ctx.Err() != nil:
+			s.breakers.cancelled(breakerCfg, up, time.Since(start))
+		default:
+			s.breakers.record(breakerCfg, up, time.Since(start), 0)
+		}
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, err
+		}
+		if hasNext && resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			// 该 key 已被负载均衡暂时移出，换下一个 key 比等待限流解除更快
+			return resp, err
+		}
+
+		wait, ok := policy.delay(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		if deadline, has := ctx.Deadline(); has && time.Now().Add(wait).After(deadline) {
+			return resp, err
+		}
+
+		if !s.breakers.allow(breakerCfg, up) {
+			// 本次失败使熔断打开，不再重试该上游
+			return resp, err
+		}
+
+		reason := "请求失败"
+		if err != nil {
+			reason += ": " + err.Error()
+		} else {
+			reason = "返回 " + strconv.Itoa(resp.StatusCode)
+			closeIO(resp.Body)
+		}
+		log.Printf("上游 %s %s，%s 后重试 (%d/%d)", up.label(), reason, wait.Round(time.Millisecond), attempt+1, policy.MaxAttempts)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
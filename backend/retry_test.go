@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyUpstream 按 Authorization 中的 key 依次返回 statuses 中的状态码，用完后返回 200，并记录每个 key 的请求次数
+func keyUpstream(t *testing.T, statuses map[string][]int) (string, func(key string) int) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	url := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		n := hits[key]
+		hits[key]++
+		mu.Unlock()
+
+		status := http.StatusOK
+		if n < len(statuses[key]) {
+			status = statuses[key][n]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, `{}`)
+	}).URL
+	return url, func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[key]
+	}
+}
+
+func chatOnce(t *testing.T, s *ProxyService) *http.Response {
+	t.Helper()
+	snapshot := s.current()
+	ups := s.balancer.order(RouteChat, snapshot.cfg.ChatBalance, snapshot.cfg.chatUpstreams())
+	resp, err := s.forwardFailover(context.Background(), snapshot, snapshot.cfg.ChatRetry, RouteChat, ups, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeIO(resp.Body)
+	return resp
+}
+
+func TestRetrySingleKeyBacksOff(t *testing.T) {
+	upstream, hits := keyUpstream(t, map[string][]int{"sk-test": {http.StatusTooManyRequests, http.StatusServiceUnavailable}})
+	s := newTestService(t, upstream, func(cfg *config) {
+		cfg.ChatRetry = RetryPolicy{MaxAttempts: 3, BaseDelayMs: 10, MaxDelayMs: 50}
+	})
+
+	if resp := chatOnce(t, s); resp.StatusCode != http.StatusOK {
+		t.Errorf("重试后应成功，实际 %d", resp.StatusCode)
+	}
+	if n := hits("sk-test"); n != 3 {
+		t.Errorf("只有一个 key 时应重试同一个 key，请求了 %d 次", n)
+	}
+}
+
+func TestRetry429MovesToNextKey(t *testing.T) {
+	upstream, hits := keyUpstream(t, map[string][]int{"sk-test": {http.StatusTooManyRequests, http.StatusTooManyRequests}})
+	s := newTestService(t, upstream, func(cfg *config) {
+		cfg.ChatApiKeys = []string{"sk-2"}
+		cfg.ChatRetry = RetryPolicy{MaxAttempts: 3, BaseDelayMs: 1000, MaxDelayMs: 1000}
+	})
+
+	start := time.Now()
+	if resp := chatOnce(t, s); resp.StatusCode != http.StatusOK {
+		t.Errorf("应由第二个 key 处理，实际 %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("429 后等待了 %s 才换 key", elapsed)
+	}
+	if hits("sk-test") != 1 || hits("sk-2") != 1 {
+		t.Errorf("sk-test 请求 %d 次，sk-2 请求 %d 次，期望各 1 次", hits("sk-test"), hits("sk-2"))
+	}
+
+	// 被 429 的 key 暂时移出候选列表，下一个请求直接使用 sk-2
+	chatOnce(t, s)
+	if hits("sk-test") != 1 || hits("sk-2") != 2 {
+		t.Errorf("sk-test 请求 %d 次，sk-2 请求 %d 次", hits("sk-test"), hits("sk-2"))
+	}
+}
+
+func TestRetry5xxRetriesSameKeyFirst(t *testing.T) {
+	upstream, hits := keyUpstream(t, map[string][]int{"sk-test": {http.StatusServiceUnavailable}})
+	s := newTestService(t, upstream, func(cfg *config) {
+		cfg.ChatApiKeys = []string{"sk-2"}
+		cfg.ChatRetry = RetryPolicy{MaxAttempts: 2, BaseDelayMs: 10, MaxDelayMs: 50}
+	})
+
+	chatOnce(t, s)
+	if hits("sk-test") != 2 || hits("sk-2") != 0 {
+		t.Errorf("503 应先重试同一个 key: sk-test %d 次，sk-2 %d 次", hits("sk-test"), hits("sk-2"))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"秒数", http.Header{"Retry-After": {"2"}}, 2 * time.Second},
+		{"毫秒", http.Header{"Retry-After-Ms": {"150"}}, 150 * time.Millisecond},
+		{"OpenAI 重置时间取最长", http.Header{"X-Ratelimit-Reset-Requests": {"1s"}, "X-Ratelimit-Reset-Tokens": {"6m0s"}}, 6 * time.Minute},
+		{"无效值", http.Header{"Retry-After": {"soon"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.header); got != tt.want {
+				t.Errorf("retryAfter = %s，期望 %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,15 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
-	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -26,123 +24,12 @@ const DefaultInstructModel = "gpt-3.5-turbo-instruct"
 const StableCodeModelPrefix = "stable-code"
 const DeepSeekCoderModel = "deepseek-coder"
 
-type config struct {
-	Bind                 string            `json:"bind"`
-	ProxyUrl             string            `json:"proxy_url"`
-	Timeout              int               `json:"timeout"`
-	CodexApiBase         string            `json:"codex_api_base"`
-	CodexApiKey          string            `json:"codex_api_key"`
-	CodexApiOrganization string            `json:"codex_api_organization"`
-	CodexApiProject      string            `json:"codex_api_project"`
-	CodexMaxTokens       int               `json:"codex_max_tokens"`
-	CodeInstructModel    string            `json:"code_instruct_model"`
-	ChatApiBase          string            `json:"chat_api_base"`
-	ChatApiKey           string            `json:"chat_api_key"`
-	ChatApiOrganization  string            `json:"chat_api_organization"`
-	ChatApiProject       string            `json:"chat_api_project"`
-	ChatMaxTokens        int               `json:"chat_max_tokens"`
-	ChatModelDefault     string            `json:"chat_model_default"`
-	ChatModelMap         map[string]string `json:"chat_model_map"`
-	ChatLocale           string            `json:"chat_locale"`
-	AuthToken            string            `json:"auth_token"`
-}
 type ResponseData struct {
 	Status string      `json:"status"`
 	Data   interface{} `json:"data"`
 	Msg    string      `json:"msg"`
 }
 
-func ReadConfig() ResponseData {
-	content, err := os.ReadFile("config.json")
-	if err != nil {
-		return ResponseData{
-			Status: "fail",
-			Data:   nil,
-			Msg:    "无法读取配置文件: " + err.Error(),
-		}
-	}
-	var cfg config
-	if err = json.Unmarshal(content, &cfg); err != nil {
-		return ResponseData{
-			Status: "fail",
-			Data:   nil,
-			Msg:    "配置解析失败: " + err.Error(),
-		}
-	}
-
-	v := reflect.ValueOf(&cfg).Elem()
-	t := v.Type()
-
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		tag := t.Field(i).Tag.Get("json")
-		if tag == "" {
-			continue
-		}
-
-		value, exists := os.LookupEnv("OVERRIDE_" + strings.ToUpper(tag))
-		if exists {
-			switch field.Kind() {
-			case reflect.String:
-				field.SetString(value)
-			case reflect.Bool:
-				if boolValue, err := strconv.ParseBool(value); err == nil {
-					field.SetBool(boolValue)
-				}
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
-					field.SetInt(intValue)
-				}
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-				if uintValue, err := strconv.ParseUint(value, 10, 64); err == nil {
-					field.SetUint(uintValue)
-				}
-			case reflect.Float32, reflect.Float64:
-				if floatValue, err := strconv.ParseFloat(value, field.Type().Bits()); err == nil {
-					field.SetFloat(floatValue)
-				}
-			}
-		}
-	}
-
-	return ResponseData{
-		Status: "success",
-		Data:   cfg,
-		Msg:    "配置加载成功",
-	}
-}
-func UpdateConfig(configData string) ResponseData {
-	var cfg config
-	err := json.Unmarshal([]byte(configData), &cfg)
-	if err != nil {
-		return ResponseData{
-			Status: "fail",
-			Msg:    "配置解析错误: " + err.Error(),
-		}
-	}
-
-	fileData, err := json.MarshalIndent(cfg, "", "    ")
-	if err != nil {
-		return ResponseData{
-			Status: "fail",
-			Msg:    "配置序列化错误: " + err.Error(),
-		}
-	}
-
-	if err := os.WriteFile("config.json", fileData, 0644); err != nil {
-		return ResponseData{
-			Status: "fail",
-			Msg:    "配置文件写入错误: " + err.Error(),
-		}
-	}
-
-	return ResponseData{
-		Status: "success",
-		Data:   cfg,
-		Msg:    "配置已成功更新",
-	}
-}
-
 func getClient(cfg *config) (*http.Client, error) {
 	transport := &http.Transport{
 		ForceAttemptHTTP2: true,
@@ -177,6 +64,22 @@ func abortCodex(c *gin.Context, status int) {
 	c.Abort()
 }
 
+// abortCodexError 结束补全流前先输出错误信息，客户端可以看到失败原因而不只是状态码
+func abortCodexError(c *gin.Context, status int, message string) {
+	data, _ := json.Marshal(gin.H{"error": gin.H{"message": message}})
+	c.Header("Content-Type", "text/event-stream")
+	c.String(status, "data: %s\n\ndata: [DONE]\n", data)
+	c.Abort()
+}
+
+// upstreamErrorMessage 取出 OpenAI 格式错误中的 message，不是该格式时返回原文
+func upstreamErrorMessage(body []byte) string {
+	if message := gjson.GetBytes(body, "error.message").String(); message != "" {
+		return message
+	}
+	return string(body)
+}
+
 func closeIO(c io.Closer) {
 	err := c.Close()
 	if nil != err {
@@ -184,21 +87,49 @@ func closeIO(c io.Closer) {
 	}
 }
 
-type ProxyService struct {
+// proxySnapshot 是某一时刻的配置及其对应的 HTTP 客户端
+type proxySnapshot struct {
 	cfg    *config
 	client *http.Client
 }
 
+type ProxyService struct {
+	snapshot   atomic.Pointer[proxySnapshot]
+	modelCache modelCache
+	balancer   balancer
+	breakers   breakers
+}
+
 func NewProxyService(cfg *config) (*ProxyService, error) {
+	s := &ProxyService{}
+	if err := s.UpdateConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// UpdateConfig 原子替换配置快照，新请求使用新配置，进行中的请求继续使用旧配置完成
+func (s *ProxyService) UpdateConfig(cfg *config) error {
 	client, err := getClient(cfg)
 	if nil != err {
-		return nil, err
+		return err
 	}
 
-	return &ProxyService{
+	old := s.snapshot.Swap(&proxySnapshot{
 		cfg:    cfg,
 		client: client,
-	}, nil
+	})
+	if old != nil {
+		// 只关闭空闲连接，不影响仍在传输的流
+		old.client.CloseIdleConnections()
+	}
+
+	return nil
+}
+
+func (s *ProxyService) current() *proxySnapshot {
+	return s.snapshot.Load()
 }
 
 func AuthMiddleware(authToken string) gin.HandlerFunc {
@@ -217,7 +148,7 @@ func (s *ProxyService) InitRoutes(e *gin.Engine) {
 	e.GET("/_ping", s.pong)
 	e.GET("/models", s.models)
 	e.GET("/v1/models", s.models)
-	authToken := s.cfg.AuthToken // replace with your dynamic value as needed
+	authToken := s.current().cfg.AuthToken // replace with your dynamic value as needed
 	if authToken != "" {
 		v1 := e.Group("/:token/v1/", AuthMiddleware(authToken))
 		{
@@ -225,12 +156,16 @@ func (s *ProxyService) InitRoutes(e *gin.Engine) {
 			v1.POST("/engines/copilot-codex/completions", s.codeCompletions)
 			v1.POST("/v1/chat/completions", s.completions)
 			v1.POST("/v1/engines/copilot-codex/completions", s.codeCompletions)
+			v1.POST("/embeddings", s.embeddings)
+			v1.POST("/v1/embeddings", s.embeddings)
 		}
 	} else {
 		e.POST("/v1/chat/completions", s.completions)
 		e.POST("/v1/engines/copilot-codex/completions", s.codeCompletions)
 		e.POST("/v1/v1/chat/completions", s.completions)
 		e.POST("/v1/v1/engines/copilot-codex/completions", s.codeCompletions)
+		e.POST("/v1/embeddings", s.embeddings)
+		e.POST("/v1/v1/embeddings", s.embeddings)
 	}
 }
 
@@ -250,113 +185,17 @@ func (s *ProxyService) pong(c *gin.Context) {
 
 func (s *ProxyService) models(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"data": []gin.H{
-			{
-				"capabilities": gin.H{
-					"family": "gpt-3.5-turbo",
-					"object": "model_capabilities",
-					"type":   "chat",
-				},
-				"id":      "gpt-3.5-turbo",
-				"name":    "GPT 3.5 Turbo",
-				"object":  "model",
-				"version": "gpt-3.5-turbo-0613",
-			},
-			{
-				"capabilities": gin.H{
-					"family": "gpt-3.5-turbo",
-					"object": "model_capabilities",
-					"type":   "chat",
-				},
-				"id":      "gpt-3.5-turbo-0613",
-				"name":    "GPT 3.5 Turbo (2023-06-13)",
-				"object":  "model",
-				"version": "gpt-3.5-turbo-0613",
-			},
-			{
-				"capabilities": gin.H{
-					"family": "gpt-4",
-					"object": "model_capabilities",
-					"type":   "chat",
-				},
-				"id":      "gpt-4",
-				"name":    "GPT 4",
-				"object":  "model",
-				"version": "gpt-4-0613",
-			},
-			{
-				"capabilities": gin.H{
-					"family": "gpt-4",
-					"object": "model_capabilities",
-					"type":   "chat",
-				},
-				"id":      "gpt-4-0613",
-				"name":    "GPT 4 (2023-06-13)",
-				"object":  "model",
-				"version": "gpt-4-0613",
-			},
-			{
-				"capabilities": gin.H{
-					"family": "gpt-4-turbo",
-					"object": "model_capabilities",
-					"type":   "chat",
-				},
-				"id":      "gpt-4-0125-preview",
-				"name":    "GPT 4 Turbo (2024-01-25 Preview)",
-				"object":  "model",
-				"version": "gpt-4-0125-preview",
-			},
-			{
-				"capabilities": gin.H{
-					"family": "text-embedding-ada-002",
-					"object": "model_capabilities",
-					"type":   "embeddings",
-				},
-				"id":      "text-embedding-ada-002",
-				"name":    "Embedding V2 Ada",
-				"object":  "model",
-				"version": "text-embedding-ada-002",
-			},
-			{
-				"capabilities": gin.H{
-					"family": "text-embedding-ada-002",
-					"object": "model_capabilities",
-					"type":   "embeddings",
-				},
-				"id":      "text-embedding-ada-002-index",
-				"name":    "Embedding V2 Ada (Index)",
-				"object":  "model",
-				"version": "text-embedding-ada-002",
-			},
-			{
-				"capabilities": gin.H{
-					"family": "text-embedding-3-small",
-					"object": "model_capabilities",
-					"type":   "embeddings",
-				},
-				"id":      "text-embedding-3-small",
-				"name":    "Embedding V3 small",
-				"object":  "model",
-				"version": "text-embedding-3-small",
-			},
-			{
-				"capabilities": gin.H{
-					"family": "text-embedding-3-small",
-					"object": "model_capabilities",
-					"type":   "embeddings",
-				},
-				"id":      "text-embedding-3-small-inference",
-				"name":    "Embedding V3 small (Inference)",
-				"object":  "model",
-				"version": "text-embedding-3-small",
-			},
-		},
+		"data":   s.availableModels(c.Request.Context(), s.current()),
 		"object": "list",
 	})
 }
 
 func (s *ProxyService) completions(c *gin.Context) {
-	ctx := c.Request.Context()
+	// 客户端断开或转发失败时取消上游请求
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	snapshot := s.current()
+	cfg := snapshot.cfg
 
 	body, err := io.ReadAll(c.Request.Body)
 	if nil != err {
@@ -365,22 +204,31 @@ func (s *ProxyService) completions(c *gin.Context) {
 	}
 
 	model := gjson.GetBytes(body, "model").String()
-	if mapped, ok := s.cfg.ChatModelMap[model]; ok {
+	if mapped, ok := cfg.ChatModelMap[model]; ok {
 		model = mapped
-	} else {
-		model = s.cfg.ChatModelDefault
+	} else if !s.knownModel(cfg.chatUpstreams(), model) {
+		// 在 Copilot 中选择了 /models 列出的上游模型时原样转发，其他模型使用默认模型
+		model = cfg.ChatModelDefault
 	}
 	body, _ = sjson.SetBytes(body, "model", model)
 
-	if !gjson.GetBytes(body, "function_call").Exists() {
-		messages := gjson.GetBytes(body, "messages").Array()
+	messages := gjson.GetBytes(body, "messages").Array()
+	if !gjson.GetBytes(body, "function_call").Exists() && len(messages) > 0 {
 		lastIndex := len(messages) - 1
-		if !strings.Contains(messages[lastIndex].Get("content").String(), "Respond in the following locale") {
-			locale := s.cfg.ChatLocale
+		content := messages[lastIndex].Get("content")
+		if !strings.Contains(content.String(), "Respond in the following locale") {
+			locale := cfg.ChatLocale
 			if locale == "" {
 				locale = "zh_CN"
 			}
-			body, _ = sjson.SetBytes(body, "messages."+strconv.Itoa(lastIndex)+".content", messages[lastIndex].Get("content").String()+"Respond in the following locale: "+locale+".")
+			instruction := "Respond in the following locale: " + locale + "."
+			path := "messages." + strconv.Itoa(lastIndex) + ".content"
+			if content.IsArray() {
+				// 多段内容追加一个文本段，不能直接拼接到 JSON 上
+				body, _ = sjson.SetBytes(body, path+".-1", map[string]string{"type": "text", "text": instruction})
+			} else {
+				body, _ = sjson.SetBytes(body, path, content.String()+instruction)
+			}
 		}
 	}
 
@@ -388,32 +236,21 @@ func (s *ProxyService) completions(c *gin.Context) {
 	body, _ = sjson.DeleteBytes(body, "intent_threshold")
 	body, _ = sjson.DeleteBytes(body, "intent_content")
 
-	if int(gjson.GetBytes(body, "max_tokens").Int()) > s.cfg.ChatMaxTokens {
-		body, _ = sjson.SetBytes(body, "max_tokens", s.cfg.ChatMaxTokens)
+	if int(gjson.GetBytes(body, "max_tokens").Int()) > cfg.ChatMaxTokens {
+		body, _ = sjson.SetBytes(body, "max_tokens", cfg.ChatMaxTokens)
 	}
 
-	proxyUrl := s.cfg.ChatApiBase + "/chat/completions"
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, proxyUrl, io.NopCloser(bytes.NewBuffer(body)))
-	if nil != err {
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.cfg.ChatApiKey)
-	if s.cfg.ChatApiOrganization != "" {
-		req.Header.Set("OpenAI-Organization", s.cfg.ChatApiOrganization)
-	}
-	if s.cfg.ChatApiProject != "" {
-		req.Header.Set("OpenAI-Project", s.cfg.ChatApiProject)
-	}
-
-	resp, err := s.client.Do(req)
+	ups := s.balancer.order(RouteChat, cfg.ChatBalance, cfg.chatUpstreams())
+	resp, err := s.forwardFailover(ctx, snapshot, cfg.ChatRetry, RouteChat, ups, body)
 	if nil != err {
 		if errors.Is(err, context.Canceled) {
 			c.AbortWithStatus(http.StatusRequestTimeout)
 			return
 		}
+		if errors.Is(err, errAllBreakersOpen) {
+			c.Data(http.StatusServiceUnavailable, "application/json", openAIError(err.Error(), "upstream_unavailable"))
+			return
+		}
 
 		log.Println("request conversation failed:", err.Error())
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -428,18 +265,14 @@ func (s *ProxyService) completions(c *gin.Context) {
 		resp.Body = io.NopCloser(bytes.NewBuffer(body))
 	}
 
-	c.Status(resp.StatusCode)
-
-	contentType := resp.Header.Get("Content-Type")
-	if contentType != "" {
-		c.Header("Content-Type", contentType)
-	}
-
-	_, _ = io.Copy(c.Writer, resp.Body)
+	relayResponse(c, resp, cancel)
 }
 
 func (s *ProxyService) codeCompletions(c *gin.Context) {
-	ctx := c.Request.Context()
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	snapshot := s.current()
+	cfg := snapshot.cfg
 
 	time.Sleep(200 * time.Millisecond)
 	if ctx.Err() != nil {
@@ -453,30 +286,21 @@ func (s *ProxyService) codeCompletions(c *gin.Context) {
 		return
 	}
 
-	body = ConstructRequestBody(body, s.cfg)
-
-	proxyUrl := s.cfg.CodexApiBase + "/completions"
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, proxyUrl, io.NopCloser(bytes.NewBuffer(body)))
-	if nil != err {
-		abortCodex(c, http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.cfg.CodexApiKey)
-	if s.cfg.CodexApiOrganization != "" {
-		req.Header.Set("OpenAI-Organization", s.cfg.CodexApiOrganization)
-	}
-	if s.cfg.CodexApiProject != "" {
-		req.Header.Set("OpenAI-Project", s.cfg.CodexApiProject)
-	}
+	body = ConstructRequestBody(body, cfg)
 
-	resp, err := s.client.Do(req)
+	route := codexRoute(cfg)
+	ups := s.balancer.order(route, cfg.CodexBalance, cfg.codexUpstreams())
+	resp, err := s.forwardFailover(ctx, snapshot, cfg.CodexRetry, route, ups, body)
 	if nil != err {
 		if errors.Is(err, context.Canceled) {
 			abortCodex(c, http.StatusRequestTimeout)
 			return
 		}
+		if errors.Is(err, errAllBreakersOpen) {
+			// 熔断期间直接返回空的补全，不让每次按键都等待上游超时
+			abortCodex(c, http.StatusOK)
+			return
+		}
 
 		log.Println("request completions failed:", err.Error())
 		abortCodex(c, http.StatusInternalServerError)
@@ -486,23 +310,32 @@ func (s *ProxyService) codeCompletions(c *gin.Context) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		log.Println("request completions failed:", string(body))
+		message := upstreamErrorMessage(body)
+		log.Println("request completions failed:", message)
 
-		abortCodex(c, resp.StatusCode)
+		abortCodexError(c, resp.StatusCode, message)
 		return
 	}
 
-	c.Status(resp.StatusCode)
-
-	contentType := resp.Header.Get("Content-Type")
-	if contentType != "" {
-		c.Header("Content-Type", contentType)
+	if route == RouteChat {
+		if resp, err = chatToCompletion(resp); err != nil {
+			log.Println("request completions failed:", err.Error())
+			abortCodex(c, http.StatusBadGateway)
+			return
+		}
+		defer closeIO(resp.Body)
 	}
 
-	_, _ = io.Copy(c.Writer, resp.Body)
+	relayResponse(c, resp, cancel)
 }
 
 func ConstructRequestBody(body []byte, cfg *config) []byte {
+	data := codexPromptData{
+		Prefix:   gjson.GetBytes(body, "prompt").String(),
+		Suffix:   gjson.GetBytes(body, "suffix").String(),
+		Language: gjson.GetBytes(body, "extra.language").String(),
+		Repo:     gjson.GetBytes(body, "nwo").String(),
+	}
 	body, _ = sjson.DeleteBytes(body, "extra")
 	body, _ = sjson.DeleteBytes(body, "nwo")
 	body, _ = sjson.SetBytes(body, "model", cfg.CodeInstructModel)
@@ -511,34 +344,43 @@ func ConstructRequestBody(body []byte, cfg *config) []byte {
 		body, _ = sjson.SetBytes(body, "max_tokens", cfg.CodexMaxTokens)
 	}
 
-	if strings.Contains(cfg.CodeInstructModel, StableCodeModelPrefix) {
-		return constructWithStableCodeModel(body)
+	if cfg.CodexMode == CodexModeChat {
+		return constructWithCodeChat(body, cfg, data)
+	}
+
+	if cfg.CodexProvider == (geminiProvider{}).Name() {
+		// Gemini 没有 FIM 接口，匹配到模板时按模板拼接 prompt，否则由 provider 使用默认的补全指令
+		if t, ok := matchFIMTemplate(cfg.FIMTemplates, cfg.CodeInstructModel); ok {
+			t.Chat = false
+			return constructFIM(body, t, data)
+		}
+		return body
+	}
+
+	// 以下按模型名的特殊处理只针对 OpenAI 兼容接口，其他 provider 自行转换请求格式
+	if cfg.CodexProvider != "" && cfg.CodexProvider != DefaultProviderName {
+		return body
+	}
+
+	if t, ok := matchFIMTemplate(cfg.FIMTemplates, cfg.CodeInstructModel); ok {
+		return constructFIM(body, t, data)
 	} else if strings.HasPrefix(cfg.CodeInstructModel, DeepSeekCoderModel) {
 		if gjson.GetBytes(body, "n").Int() > 1 {
 			body, _ = sjson.SetBytes(body, "n", 1)
 		}
 	}
 
-	// if strings.HasSuffix(cfg.ChatApiBase, "chat") {
-	// 	// @Todo  constructWithChatModel
-	// 	// 如果code base以chat结尾则构建chatModel，暂时没有好的prompt
-	// }
-
 	return body
 }
 
-func constructWithStableCodeModel(body []byte) []byte {
-	suffix := gjson.GetBytes(body, "suffix")
-	prompt := gjson.GetBytes(body, "prompt")
-	content := fmt.Sprintf("<fim_prefix>%s<fim_suffix>%s<fim_middle>", prompt, suffix)
-
-	messages := []map[string]string{
-		{
-			"role":    "user",
-			"content": content,
-		},
+// constructFIM 按模板构造请求体，渲染失败时原样返回
+func constructFIM(body []byte, t FIMTemplate, data codexPromptData) []byte {
+	fimBody, err := t.construct(body, data)
+	if err != nil {
+		log.Println("fim 模板渲染失败:", t.Model, err)
+		return body
 	}
-	return constructWithChatModel(body, messages)
+	return fimBody
 }
 
 func constructWithChatModel(body []byte, messages interface{}) []byte {
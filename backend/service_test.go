@@ -0,0 +1,48 @@
+package backend
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestService 创建请求 upstream 的代理服务，默认关闭重试和熔断，由 configure 按需修改配置
+func newTestService(t *testing.T, upstream string, configure func(cfg *config)) *ProxyService {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.ChatApiBase = upstream
+	cfg.CodexApiBase = upstream
+	cfg.ChatApiKey = "sk-test"
+	cfg.CodexApiKey = "sk-test"
+	cfg.ChatRetry = RetryPolicy{}
+	cfg.CodexRetry = RetryPolicy{}
+	cfg.CircuitBreaker = BreakerConfig{}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	s, err := NewProxyService(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newUpstream 启动测试用上游，测试结束时关闭
+func newUpstream(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
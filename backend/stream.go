@@ -0,0 +1,230 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sseEvent 一条 SSE 事件，多行 data 按换行拼接
+type sseEvent struct {
+	Event string
+	Data  []byte
+}
+
+// readSSE 逐条读取 SSE 事件，fn 返回错误时停止
+func readSSE(r io.Reader, fn func(ev sseEvent) error) error {
+	reader := bufio.NewReader(r)
+	var ev sseEvent
+	var data [][]byte
+
+	flush := func() error {
+		if ev.Event == "" && len(data) == 0 {
+			return nil
+		}
+		ev.Data = bytes.Join(data, []byte("\n"))
+		err := fn(ev)
+		ev, data = sseEvent{}, nil
+		return err
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimRight(line, "\r\n")
+			switch {
+			case len(line) == 0:
+				if ferr := flush(); ferr != nil {
+					return ferr
+				}
+			case line[0] == ':':
+				// 注释行，常用作心跳
+			default:
+				field, value, _ := bytes.Cut(line, []byte(":"))
+				value = bytes.TrimPrefix(value, []byte(" "))
+				switch string(field) {
+				case "event":
+					ev.Event = string(value)
+				case "data":
+					data = append(data, value)
+				}
+			}
+		}
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLines 逐行读取按换行分隔的 JSON（NDJSON），跳过空行
+func readLines(r io.Reader, fn func(line []byte) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if ferr := fn(line); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pipeResponse 用 translate 转换后的内容替换响应体，转换在单独的 goroutine 中边读边写，
+// 调用方关闭新的响应体时上游连接随之关闭
+func pipeResponse(resp *http.Response, contentType string, translate func(src io.Reader, dst io.Writer) error) *http.Response {
+	pr, pw := io.Pipe()
+	upstream := resp.Body
+	go func() {
+		err := translate(upstream, pw)
+		closeIO(upstream)
+		_ = pw.CloseWithError(err)
+	}()
+
+	out := *resp
+	out.Header = resp.Header.Clone()
+	out.Header.Set("Content-Type", contentType)
+	out.Header.Del("Content-Length")
+	out.ContentLength = -1
+	out.Body = pr
+	return &out
+}
+
+// replaceJSONBody 读取完整的非流式响应体，转换后替换
+func replaceJSONBody(resp *http.Response, translate func(body []byte) ([]byte, error)) (*http.Response, error) {
+	body, err := io.ReadAll(resp.Body)
+	closeIO(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err = translate(body)
+	if err != nil {
+		return nil, err
+	}
+
+	out := *resp
+	out.Header = resp.Header.Clone()
+	out.Header.Set("Content-Type", "application/json")
+	out.Header.Del("Content-Length")
+	out.ContentLength = int64(len(body))
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	return &out, nil
+}
+
+// isEventStream 判断上游响应是否为 SSE 流
+func isEventStream(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// openAIError 构造 OpenAI 格式的错误响应体
+func openAIError(message, errType string) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+		},
+	})
+	return body
+}
+
+// chunkWriter 输出 OpenAI chat.completion.chunk 格式的 SSE
+type chunkWriter struct {
+	w       io.Writer
+	id      string
+	model   string
+	created int64
+}
+
+func newChunkWriter(w io.Writer, model string) *chunkWriter {
+	return &chunkWriter{
+		w:       w,
+		id:      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		model:   model,
+		created: time.Now().Unix(),
+	}
+}
+
+// write 输出一个 chunk，finishReason 为空时输出 null
+func (c *chunkWriter) write(delta map[string]interface{}, finishReason string) error {
+	choice := map[string]interface{}{
+		"index":         0,
+		"delta":         delta,
+		"finish_reason": nil,
+	}
+	if finishReason != "" {
+		choice["finish_reason"] = finishReason
+	}
+
+	return c.writeRaw(map[string]interface{}{
+		"id":      c.id,
+		"object":  "chat.completion.chunk",
+		"created": c.created,
+		"model":   c.model,
+		"choices": []interface{}{choice},
+	})
+}
+
+func (c *chunkWriter) writeRaw(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "data: %s\n\n", data)
+	return err
+}
+
+func (c *chunkWriter) done() error {
+	_, err := io.WriteString(c.w, "data: [DONE]\n\n")
+	return err
+}
+
+// completionWriter 输出 OpenAI text_completion 格式的 SSE，供代码补全使用
+type completionWriter struct {
+	chunkWriter
+}
+
+func newCompletionWriter(w io.Writer, model string) *completionWriter {
+	cw := &completionWriter{*newChunkWriter(w, model)}
+	cw.id = fmt.Sprintf("cmpl-%d", time.Now().UnixNano())
+	return cw
+}
+
+// write 输出一段补全文本，finishReason 为空时输出 null
+func (c *completionWriter) write(text, finishReason string) error {
+	return c.writeRaw(textCompletion(c.id, c.model, c.created, text, finishReason))
+}
+
+// textCompletion 构造 text_completion 对象，流式和非流式响应共用
+func textCompletion(id, model string, created int64, text, finishReason string) map[string]interface{} {
+	choice := map[string]interface{}{
+		"index":         0,
+		"text":          text,
+		"logprobs":      nil,
+		"finish_reason": nil,
+	}
+	if finishReason != "" {
+		choice["finish_reason"] = finishReason
+	}
+
+	return map[string]interface{}{
+		"id":      id,
+		"object":  "text_completion",
+		"created": created,
+		"model":   model,
+		"choices": []interface{}{choice},
+	}
+}
@@ -0,0 +1,274 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// knownLocales Copilot 回复语言可选值
+var knownLocales = map[string]bool{
+	"zh_CN": true,
+	"zh_TW": true,
+	"en_US": true,
+	"en_GB": true,
+	"ja_JP": true,
+	"ko_KR": true,
+	"fr_FR": true,
+	"de_DE": true,
+	"es_ES": true,
+	"it_IT": true,
+	"pt_BR": true,
+	"ru_RU": true,
+}
+
+// FieldError 单个配置字段的校验错误，Field 与配置的 json 字段名一致
+type FieldError struct {
+	Field string `json:"field"`
+	Msg   string `json:"msg"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// validateConfig 校验配置的每个字段，返回全部错误而不是遇到第一个就停止。
+// 错误按字段排序，调用方取第一个错误提示时结果稳定
+func validateConfig(cfg *config) []FieldError {
+	var errs []FieldError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if msg := checkHostPort(cfg.Bind); msg != "" {
+		add("bind", msg)
+	}
+
+	if cfg.ProxyUrl != "" {
+		if msg := checkURL(cfg.ProxyUrl, "http", "https", "socks5"); msg != "" {
+			add("proxy_url", msg)
+		}
+	}
+
+	if cfg.Timeout < 0 {
+		add("timeout", "超时时间不能为负数")
+	}
+
+	if msg := checkProvider(cfg.CodexProvider); msg != "" {
+		add("codex_provider", msg)
+	}
+	if msg := checkURL(cfg.CodexApiBase, "http", "https"); msg != "" {
+		add("codex_api_base", msg)
+	}
+	if cfg.CodexMaxTokens <= 0 {
+		add("codex_max_tokens", "必须为正整数")
+	}
+	if strings.TrimSpace(cfg.CodeInstructModel) == "" {
+		add("code_instruct_model", "不能为空")
+	}
+	switch cfg.CodexMode {
+	case "", CodexModeCompletions, CodexModeChat:
+	default:
+		add("codex_mode", "可选值为 %s 或 %s", CodexModeCompletions, CodexModeChat)
+	}
+	if _, err := template.New("codex").Parse(cfg.CodexSystemPrompt); err != nil {
+		add("codex_system_prompt", "模板解析失败: %s", err)
+	}
+	if _, err := template.New("codex").Parse(cfg.CodexUserPrompt); err != nil {
+		add("codex_user_prompt", "模板解析失败: %s", err)
+	}
+
+	for i, t := range cfg.FIMTemplates {
+		field := fmt.Sprintf("fim_templates.%d", i)
+		if strings.TrimSpace(t.Model) == "" {
+			add(field+".model", "不能为空")
+		} else if _, err := compileModelGlob(t.Model); err != nil {
+			add(field+".model", "通配符格式错误: %s", t.Model)
+		}
+		if strings.TrimSpace(t.Template) == "" {
+			add(field+".template", "不能为空")
+		} else if _, err := template.New("fim").Parse(t.Template); err != nil {
+			add(field+".template", "模板解析失败: %s", err)
+		}
+	}
+
+	if msg := checkProvider(cfg.ChatProvider); msg != "" {
+		add("chat_provider", msg)
+	}
+	if msg := checkURL(cfg.ChatApiBase, "http", "https"); msg != "" {
+		add("chat_api_base", msg)
+	}
+	if cfg.ChatMaxTokens <= 0 {
+		add("chat_max_tokens", "必须为正整数")
+	}
+	if strings.TrimSpace(cfg.ChatModelDefault) == "" {
+		add("chat_model_default", "不能为空")
+	}
+
+	checkModelMap("chat_model_map", cfg.ChatModelMap, add)
+
+	for _, list := range []struct {
+		field     string
+		upstreams []UpstreamConfig
+	}{
+		{"chat_upstreams", cfg.ChatUpstreams},
+		{"codex_upstreams", cfg.CodexUpstreams},
+	} {
+		for i, u := range list.upstreams {
+			field := fmt.Sprintf("%s.%d", list.field, i)
+			if u.Weight < 0 {
+				add(field+".weight", "不能为负数")
+			}
+			if msg := checkProvider(u.Provider); msg != "" {
+				add(field+".provider", msg)
+			}
+			if msg := checkURL(u.ApiBase, "http", "https"); msg != "" {
+				add(field+".api_base", msg)
+			}
+		}
+	}
+
+	for _, balance := range []struct {
+		field    string
+		strategy string
+	}{
+		{"chat_balance", cfg.ChatBalance},
+		{"codex_balance", cfg.CodexBalance},
+	} {
+		switch balance.strategy {
+		case "", BalanceFailover, BalanceRoundRobin, BalanceWeighted, BalanceLeastLatency:
+		default:
+			add(balance.field, "可选值为 %s", strings.Join([]string{BalanceFailover, BalanceRoundRobin, BalanceWeighted, BalanceLeastLatency}, ", "))
+		}
+	}
+
+	for _, retry := range []struct {
+		field  string
+		policy RetryPolicy
+	}{
+		{"chat_retry", cfg.ChatRetry},
+		{"codex_retry", cfg.CodexRetry},
+	} {
+		if retry.policy.MaxAttempts < 0 || retry.policy.BaseDelayMs < 0 || retry.policy.MaxDelayMs < 0 {
+			add(retry.field, "不能为负数")
+		} else if retry.policy.MaxDelayMs > 0 && retry.policy.BaseDelayMs > retry.policy.MaxDelayMs {
+			add(retry.field, "base_delay_ms 不能大于 max_delay_ms")
+		}
+	}
+
+	if breaker := cfg.CircuitBreaker; breaker.FailureRate < 0 || breaker.FailureRate > 1 {
+		add("circuit_breaker", "failure_rate 取值范围为 0~1")
+	} else if breaker.Window < 0 || breaker.MinRequests < 0 || breaker.SlowCallMs < 0 || breaker.OpenSeconds < 0 {
+		add("circuit_breaker", "不能为负数")
+	}
+
+	if msg := checkProvider(cfg.EmbeddingsProvider); msg != "" {
+		add("embeddings_provider", msg)
+	}
+	if cfg.EmbeddingsApiBase != "" {
+		if msg := checkURL(cfg.EmbeddingsApiBase, "http", "https"); msg != "" {
+			add("embeddings_api_base", msg)
+		}
+	}
+	if cfg.EmbeddingsBatchSize < 0 {
+		add("embeddings_batch_size", "不能为负数")
+	}
+	checkModelMap("embeddings_model_map", cfg.EmbeddingsModelMap, add)
+
+	if cfg.ChatLocale != "" && !knownLocales[cfg.ChatLocale] {
+		add("chat_locale", "不支持的语言: %s", cfg.ChatLocale)
+	}
+
+	if strings.ContainsAny(cfg.AuthToken, "/?# ") {
+		add("auth_token", "不能包含 / ? # 或空格")
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return fieldLess(errs[i].Field, errs[j].Field)
+	})
+	return errs
+}
+
+// checkModelMap 校验模型映射，错误字段为 field.<模型名>，模型名为空时为 field
+func checkModelMap(field string, models map[string]string, add func(field, format string, args ...interface{})) {
+	for key, value := range models {
+		if strings.TrimSpace(key) == "" {
+			add(field, "模型名不能为空")
+		} else if strings.TrimSpace(value) == "" {
+			add(field+"."+key, "映射目标不能为空")
+		}
+	}
+}
+
+// fieldLess 按 . 分段比较字段名，数字段按数值比较，使 chat_upstreams.2 排在 chat_upstreams.10 之前
+func fieldLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		if aerr == nil && berr == nil {
+			return an < bn
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
+
+// checkHostPort 校验 host:port 格式，返回空字符串表示合法
+func checkHostPort(addr string) string {
+	if addr == "" {
+		return "不能为空"
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "格式应为 host:port"
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return "端口无效: " + port
+	}
+
+	return ""
+}
+
+// checkURL 校验绝对 URL 及其协议，返回空字符串表示合法
+func checkURL(raw string, schemes ...string) string {
+	if raw == "" {
+		return "不能为空"
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "URL 解析失败: " + err.Error()
+	}
+	if u.Host == "" {
+		return "缺少主机名"
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return ""
+		}
+	}
+	return fmt.Sprintf("不支持的协议: %s", u.Scheme)
+}
+
+// checkProvider 校验 provider 是否已注册，空值表示使用默认的 OpenAI 兼容实现
+func checkProvider(name string) string {
+	if name == "" {
+		return ""
+	}
+	if _, err := providerFor(name); err != nil {
+		return fmt.Sprintf("未知的 provider: %s，可选值: %s", name, strings.Join(providerNames(), ", "))
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*config)
+		want []FieldError
+	}{
+		{
+			name: "默认配置",
+			edit: func(*config) {},
+		},
+		{
+			name: "模型映射按模型名定位",
+			edit: func(c *config) {
+				c.ChatModelMap = map[string]string{"gpt-4o": "", "o1": " ", "gpt-4": "qwen-max", "": "x"}
+				c.EmbeddingsModelMap = map[string]string{"text-embedding-3-small": ""}
+			},
+			want: []FieldError{
+				{"chat_model_map", "模型名不能为空"},
+				{"chat_model_map.gpt-4o", "映射目标不能为空"},
+				{"chat_model_map.o1", "映射目标不能为空"},
+				{"embeddings_model_map.text-embedding-3-small", "映射目标不能为空"},
+			},
+		},
+		{
+			name: "按字段排序",
+			edit: func(c *config) {
+				c.AuthToken = "a/b"
+				c.Bind = "localhost"
+				c.CodexBalance = "random"
+				c.ChatBalance = "random"
+				c.CodexRetry.MaxAttempts = -1
+				c.ChatRetry.BaseDelayMs, c.ChatRetry.MaxDelayMs = 2000, 1000
+				c.ChatMaxTokens = 0
+			},
+			want: []FieldError{
+				{"auth_token", "不能包含 / ? # 或空格"},
+				{"bind", "格式应为 host:port"},
+				{"chat_balance", "可选值为 failover, round_robin, weighted, least_latency"},
+				{"chat_max_tokens", "必须为正整数"},
+				{"chat_retry", "base_delay_ms 不能大于 max_delay_ms"},
+				{"codex_balance", "可选值为 failover, round_robin, weighted, least_latency"},
+				{"codex_retry", "不能为负数"},
+			},
+		},
+		{
+			name: "上游序号按数值排序",
+			edit: func(c *config) {
+				for i := 0; i < 11; i++ {
+					c.ChatUpstreams = append(c.ChatUpstreams, UpstreamConfig{ApiBase: "https://api.example.com"})
+				}
+				c.ChatUpstreams[10].Weight = -1
+				c.ChatUpstreams[2].ApiBase = "ftp://api.example.com"
+				c.ChatUpstreams[2].Weight = -1
+			},
+			want: []FieldError{
+				{"chat_upstreams.2.api_base", "不支持的协议: ftp"},
+				{"chat_upstreams.2.weight", "不能为负数"},
+				{"chat_upstreams.10.weight", "不能为负数"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tt.edit(&cfg)
+			// map 遍历顺序随机，多次校验结果应一致
+			for i := 0; i < 10; i++ {
+				if got := validateConfig(&cfg); !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("validateConfig() =\n%v\nwant\n%v", got, tt.want)
+				}
+			}
+		})
+	}
+}
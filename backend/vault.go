@@ -0,0 +1,576 @@
+package backend
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// vaultFileName 密钥库文件名，与配置文件位于同一目录
+const vaultFileName = "secrets.vault"
+
+// secretRefPrefix 配置文件中引用密钥库条目的前缀，例如 "vault:3f9a..."
+const secretRefPrefix = "vault:"
+
+// secretMask 返回给前端的掩码字符
+const secretMask = "••••••"
+
+// vaultAAD 加密时的附加数据，格式升级时一并修改
+var vaultAAD = []byte("override-gui-vault-v1")
+
+// ErrVaultLocked 密钥库口令错误或尚未解锁
+var ErrVaultLocked = errors.New("密钥库口令错误，请先解锁")
+
+// vaultFile 密钥库的磁盘格式，Data 为 AES-GCM 加密后的密钥表
+type vaultFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+var (
+	vaultMu         sync.Mutex
+	vaultPassphrase string
+	// vaultKeyCache 缓存上一次派生的密钥，避免每次读取配置都执行 scrypt
+	vaultKeyCache struct {
+		passphrase string
+		salt       string
+		key        []byte
+	}
+)
+
+func vaultPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), vaultFileName)
+}
+
+// SetVaultPassphrase 设置密钥库口令，已有密钥库时会先校验口令是否正确
+func SetVaultPassphrase(passphrase string) error {
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+
+	previous := vaultPassphrase
+	vaultPassphrase = passphrase
+	if _, err := readVault(); err != nil {
+		vaultPassphrase = previous
+		return err
+	}
+	return nil
+}
+
+// ChangeVaultPassphrase 用旧口令解密密钥库后以新口令重新加密。oldPassphrase 为空时使用当前生效的口令；
+// newPassphrase 为空时恢复为 OVERRIDE_VAULT_PASSPHRASE 或本机默认口令。
+// 自定义口令不会保存到磁盘，程序重启后需要重新解锁。
+func ChangeVaultPassphrase(oldPassphrase, newPassphrase string) error {
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+
+	previous := vaultPassphrase
+	if oldPassphrase != "" {
+		vaultPassphrase = oldPassphrase
+	}
+	secrets, err := readVault()
+	if err != nil {
+		vaultPassphrase = previous
+		return err
+	}
+
+	vaultPassphrase = newPassphrase
+	if err := writeVault(secrets); err != nil {
+		vaultPassphrase = previous
+		return err
+	}
+	return nil
+}
+
+// VaultStatus 密钥库状态，供前端判断是否需要输入口令
+type VaultStatus struct {
+	Exists bool `json:"exists"`
+	Locked bool `json:"locked"`
+	// Custom 是否使用手动输入或环境变量设置的口令，而不是本机默认口令
+	Custom bool `json:"custom"`
+}
+
+// ReadVaultStatus 检查当前口令能否解开密钥库
+func ReadVaultStatus() VaultStatus {
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+
+	status := VaultStatus{
+		Custom: vaultPassphrase != "" || os.Getenv("OVERRIDE_VAULT_PASSPHRASE") != "",
+	}
+	if _, err := os.Stat(vaultPath()); err != nil {
+		return status
+	}
+	status.Exists = true
+	_, err := readVault()
+	status.Locked = errors.Is(err, ErrVaultLocked)
+	return status
+}
+
+// currentPassphrase 按手动解锁、OVERRIDE_VAULT_PASSPHRASE、本机默认口令的顺序选择口令。
+// 本机默认口令只能防止配置文件被直接拷走后泄露密钥，需要更强保护时请设置自己的口令。
+func currentPassphrase() string {
+	if vaultPassphrase != "" {
+		return vaultPassphrase
+	}
+	if passphrase := os.Getenv("OVERRIDE_VAULT_PASSPHRASE"); passphrase != "" {
+		return passphrase
+	}
+
+	host, _ := os.Hostname()
+	username := ""
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	return "override-gui:" + host + ":" + username
+}
+
+func deriveVaultKey(passphrase string, vf *vaultFile) ([]byte, error) {
+	if vaultKeyCache.key != nil && vaultKeyCache.passphrase == passphrase && vaultKeyCache.salt == string(vf.Salt) {
+		return vaultKeyCache.key, nil
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), vf.Salt, vf.N, vf.R, vf.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	vaultKeyCache.passphrase = passphrase
+	vaultKeyCache.salt = string(vf.Salt)
+	vaultKeyCache.key = key
+	return key, nil
+}
+
+func newVaultGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readVault 解密并返回全部密钥，密钥库不存在时返回空表，调用方需持有 vaultMu
+func readVault() (map[string]string, error) {
+	content, err := os.ReadFile(vaultPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var vf vaultFile
+	if err := json.Unmarshal(content, &vf); err != nil {
+		return nil, fmt.Errorf("密钥库格式错误: %w", err)
+	}
+	if vf.KDF != "scrypt" {
+		return nil, fmt.Errorf("不支持的密钥派生算法: %s", vf.KDF)
+	}
+
+	key, err := deriveVaultKey(currentPassphrase(), &vf)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newVaultGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, vf.Nonce, vf.Data, vaultAAD)
+	if err != nil {
+		return nil, ErrVaultLocked
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("密钥库内容损坏: %w", err)
+	}
+	return secrets, nil
+}
+
+// writeVault 使用新的盐和随机数重新加密全部密钥，调用方需持有 vaultMu
+func writeVault(secrets map[string]string) error {
+	vf := vaultFile{
+		Version: 1,
+		KDF:     "scrypt",
+		N:       1 << 15,
+		R:       8,
+		P:       1,
+		Salt:    make([]byte, 16),
+	}
+	if _, err := rand.Read(vf.Salt); err != nil {
+		return err
+	}
+
+	key, err := deriveVaultKey(currentPassphrase(), &vf)
+	if err != nil {
+		return err
+	}
+	gcm, err := newVaultGCM(key)
+	if err != nil {
+		return err
+	}
+	vf.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(vf.Nonce); err != nil {
+		return err
+	}
+
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	vf.Data = gcm.Seal(nil, vf.Nonce, plain, vaultAAD)
+
+	content, err := json.MarshalIndent(vf, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(vaultPath(), content, 0600)
+}
+
+func newSecretID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// forEachSecret 遍历所有带 secret:"true" 标签的字符串字段及字符串数组的元素，path 为以点分隔的 json 字段路径
+func forEachSecret(v reflect.Value, path string, fn func(path string, field reflect.Value) error) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return forEachSecret(v.Elem(), path, fn)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := forEachSecret(v.Index(i), fmt.Sprintf("%s.%d", path, i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			fieldPath := tag
+			if path != "" {
+				fieldPath = path + "." + tag
+			}
+
+			field := v.Field(i)
+			if t.Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String {
+				if err := fn(fieldPath, field); err != nil {
+					return err
+				}
+				continue
+			}
+			if t.Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String {
+				for j := 0; j < field.Len(); j++ {
+					if err := fn(fmt.Sprintf("%s.%d", fieldPath, j), field.Index(j)); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := forEachSecret(field, fieldPath, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretRefPrefix)
+}
+
+func isMaskedSecret(value string) bool {
+	return strings.Contains(value, secretMask)
+}
+
+// maskSecret 只保留首尾少量字符，便于用户辨认是哪一个密钥
+func maskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	if utf8.RuneCountInString(value) <= 12 {
+		return secretMask
+	}
+	runes := []rune(value)
+	return string(runes[:3]) + secretMask + string(runes[len(runes)-4:])
+}
+
+// maskSecrets 将配置中的密钥替换为掩码后返回，供前端展示
+func maskSecrets(cfg config) config {
+	cfg = cloneConfig(cfg)
+	_ = forEachSecret(reflect.ValueOf(&cfg), "", func(_ string, field reflect.Value) error {
+		field.SetString(maskSecret(field.String()))
+		return nil
+	})
+	return cfg
+}
+
+// resolveSecrets 将配置中的密钥引用替换为明文
+func resolveSecrets(cfg *config) error {
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+
+	var secrets map[string]string
+	return forEachSecret(reflect.ValueOf(cfg), "", func(path string, field reflect.Value) error {
+		if !isSecretRef(field.String()) {
+			return nil
+		}
+		if secrets == nil {
+			var err error
+			if secrets, err = readVault(); err != nil {
+				return err
+			}
+		}
+
+		value, ok := secrets[strings.TrimPrefix(field.String(), secretRefPrefix)]
+		if !ok {
+			return fmt.Errorf("密钥库中缺少 %s 引用的密钥", path)
+		}
+		field.SetString(value)
+		return nil
+	})
+}
+
+// mergeSecrets 处理前端提交的配置：掩码值保留原有密钥，新的明文存入密钥库并替换为引用
+func mergeSecrets(cfg *config, previous config) error {
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+
+	var secrets map[string]string
+	loadSecrets := func() error {
+		if secrets != nil {
+			return nil
+		}
+		var err error
+		secrets, err = readVault()
+		return err
+	}
+
+	masked := newMaskedSecrets(previous, func(value string) (string, error) {
+		if !isSecretRef(value) {
+			return value, nil
+		}
+		if err := loadSecrets(); err != nil {
+			return "", err
+		}
+		return secrets[strings.TrimPrefix(value, secretRefPrefix)], nil
+	})
+
+	changed := false
+	err := forEachSecret(reflect.ValueOf(cfg), "", func(path string, field reflect.Value) error {
+		value := field.String()
+		switch {
+		case value == "" || isSecretRef(value):
+			return nil
+		case isMaskedSecret(value):
+			original, err := masked.restore(cfg, path, value)
+			if err != nil {
+				return err
+			}
+			field.SetString(original)
+			return nil
+		}
+
+		if err := loadSecrets(); err != nil {
+			return err
+		}
+		id, err := newSecretID()
+		if err != nil {
+			return err
+		}
+		secrets[id] = value
+		field.SetString(secretRefPrefix + id)
+		changed = true
+		return nil
+	})
+	if err != nil || !changed {
+		return err
+	}
+	return writeVault(secrets)
+}
+
+// maskedSecrets 根据前端回传的掩码找回原有的密钥。
+// 上游中的密钥按上游名称（未命名时按 api base）对应，key 列表中的元素按掩码对应，
+// 删除或调整上游、key 的顺序后各自的密钥不会错位。不足 13 个字符的密钥掩码相同，只能按位置对应。
+type maskedSecrets struct {
+	// slots 原配置中的密钥，按 secretSlot 计算的位置分组
+	slots map[string][]string
+	used  map[string][]bool
+	// positions 原配置中按下标表示的位置到 slots 键的映射，上游改名时按原位置查找
+	positions map[string]string
+	plain     func(value string) (string, error)
+}
+
+// newMaskedSecrets plain 将原配置中的值（可能是密钥库引用）转换为明文，用于比较掩码
+func newMaskedSecrets(previous config, plain func(value string) (string, error)) *maskedSecrets {
+	m := &maskedSecrets{
+		slots:     map[string][]string{},
+		used:      map[string][]bool{},
+		positions: map[string]string{},
+		plain:     plain,
+	}
+	_ = forEachSecret(reflect.ValueOf(&previous), "", func(path string, field reflect.Value) error {
+		slot, position, _ := secretSlot(&previous, path)
+		m.slots[slot] = append(m.slots[slot], field.String())
+		m.used[slot] = append(m.used[slot], false)
+		m.positions[position] = slot
+		return nil
+	})
+	return m
+}
+
+// restore 返回掩码 masked 在原配置中对应的值，找不到时返回空字符串
+func (m *maskedSecrets) restore(cfg *config, path, masked string) (string, error) {
+	slot, position, index := secretSlot(cfg, path)
+	candidates := []string{slot}
+	if previous, ok := m.positions[position]; ok && previous != slot {
+		candidates = append(candidates, previous)
+	}
+
+	for _, slot := range candidates {
+		values := m.slots[slot]
+		// 优先尝试相同下标，掩码相同的短密钥才能保持原有的对应关系
+		order := make([]int, 0, len(values))
+		if index >= 0 && index < len(values) {
+			order = append(order, index)
+		}
+		for i := range values {
+			if i != index {
+				order = append(order, i)
+			}
+		}
+
+		for _, i := range order {
+			if m.used[slot][i] {
+				continue
+			}
+			plain, err := m.plain(values[i])
+			if err != nil {
+				return "", err
+			}
+			if maskSecret(plain) == masked {
+				m.used[slot][i] = true
+				return values[i], nil
+			}
+		}
+	}
+
+	log.Printf("%s 的掩码 %s 没有对应的原密钥，已清空", path, masked)
+	return "", nil
+}
+
+// secretSlot 计算密钥在配置中的稳定位置：上游下标替换为上游名称，key 列表去掉下标。
+// position 为替换前的位置，index 为 key 列表中的下标，不在列表中时为 -1。
+func secretSlot(cfg *config, path string) (slot, position string, index int) {
+	segments := strings.Split(path, ".")
+	index = -1
+	if n := len(segments); n > 1 {
+		if i, err := strconv.Atoi(segments[n-1]); err == nil {
+			index = i
+			segments = segments[:n-1]
+		}
+	}
+	position = strings.Join(segments, ".")
+
+	if len(segments) > 2 {
+		var upstreams []UpstreamConfig
+		switch segments[0] {
+		case "chat_upstreams":
+			upstreams = cfg.ChatUpstreams
+		case "codex_upstreams":
+			upstreams = cfg.CodexUpstreams
+		}
+		if i, err := strconv.Atoi(segments[1]); err == nil && i < len(upstreams) {
+			name := upstreams[i].Name
+			if name == "" {
+				name = upstreams[i].ApiBase
+			}
+			segments = append([]string{segments[0], "[" + name + "]"}, segments[2:]...)
+		}
+	}
+	return strings.Join(segments, "."), position, index
+}
+
+// sealPlaintextSecrets 将配置文件中残留的明文密钥移入密钥库，返回是否有修改
+func sealPlaintextSecrets(doc *configFile) (bool, error) {
+	changed := false
+	for name, cfg := range doc.Profiles {
+		plaintext := false
+		_ = forEachSecret(reflect.ValueOf(&cfg), "", func(_ string, field reflect.Value) error {
+			if field.String() != "" && !isSecretRef(field.String()) {
+				plaintext = true
+			}
+			return nil
+		})
+		if !plaintext {
+			continue
+		}
+
+		if err := mergeSecrets(&cfg, config{}); err != nil {
+			return changed, err
+		}
+		doc.Profiles[name] = cfg
+		changed = true
+	}
+	return changed, nil
+}
+
+// pruneVault 删除已没有任何 profile 引用的密钥
+func pruneVault(doc *configFile) error {
+	used := map[string]bool{}
+	for _, cfg := range doc.Profiles {
+		_ = forEachSecret(reflect.ValueOf(&cfg), "", func(_ string, field reflect.Value) error {
+			if isSecretRef(field.String()) {
+				used[strings.TrimPrefix(field.String(), secretRefPrefix)] = true
+			}
+			return nil
+		})
+	}
+
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+
+	secrets, err := readVault()
+	if err != nil {
+		return err
+	}
+	pruned := false
+	for id := range secrets {
+		if !used[id] {
+			delete(secrets, id)
+			pruned = true
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	return writeVault(secrets)
+}
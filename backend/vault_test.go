@@ -0,0 +1,284 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// useVault 在临时目录中使用新的配置文件和密钥库，测试结束时恢复口令
+func useVault(t *testing.T, config string) {
+	t.Helper()
+	useConfigFile(t, "config.json", config)
+	t.Setenv("OVERRIDE_VAULT_PASSPHRASE", "")
+	vaultMu.Lock()
+	vaultPassphrase = ""
+	vaultMu.Unlock()
+	t.Cleanup(func() {
+		vaultMu.Lock()
+		vaultPassphrase = ""
+		vaultMu.Unlock()
+	})
+}
+
+// sealSecret 把明文存入密钥库，返回配置中使用的引用
+func sealSecret(t *testing.T, value string) string {
+	t.Helper()
+	cfg := config{ChatApiKey: value}
+	if err := mergeSecrets(&cfg, config{}); err != nil {
+		t.Fatal(err)
+	}
+	return cfg.ChatApiKey
+}
+
+func readSecret(ref string) (string, error) {
+	cfg := config{ChatApiKey: ref}
+	err := resolveSecrets(&cfg)
+	return cfg.ChatApiKey, err
+}
+
+func TestChangeVaultPassphrase(t *testing.T) {
+	useVault(t, `{}`)
+	ref := sealSecret(t, "sk-secret")
+
+	// 从本机默认口令改为自定义口令，旧口令留空表示当前口令
+	if err := ChangeVaultPassphrase("", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readSecret(ref); err != nil || got != "sk-secret" {
+		t.Fatalf("修改口令后读取 = %q, %v", got, err)
+	}
+	if status := ReadVaultStatus(); !status.Exists || status.Locked || !status.Custom {
+		t.Errorf("状态 = %+v", status)
+	}
+
+	// 模拟重启：口令没有保存，密钥库处于锁定状态
+	vaultMu.Lock()
+	vaultPassphrase = ""
+	vaultMu.Unlock()
+	if _, err := readSecret(ref); !errors.Is(err, ErrVaultLocked) {
+		t.Fatalf("重启后应为锁定状态，实际 %v", err)
+	}
+	if status := ReadVaultStatus(); !status.Locked {
+		t.Errorf("状态 = %+v，期望 locked", status)
+	}
+	if err := SetVaultPassphrase("wrong"); !errors.Is(err, ErrVaultLocked) {
+		t.Errorf("错误的口令应解锁失败，实际 %v", err)
+	}
+	if err := SetVaultPassphrase("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 旧口令错误时不修改密钥库
+	if err := ChangeVaultPassphrase("wrong", "other"); !errors.Is(err, ErrVaultLocked) {
+		t.Fatalf("旧口令错误应返回 ErrVaultLocked，实际 %v", err)
+	}
+	if got, err := readSecret(ref); err != nil || got != "sk-secret" {
+		t.Fatalf("修改失败后读取 = %q, %v", got, err)
+	}
+
+	// 新口令为空时恢复本机默认口令
+	if err := ChangeVaultPassphrase("correct horse", ""); err != nil {
+		t.Fatal(err)
+	}
+	vaultMu.Lock()
+	vaultPassphrase = ""
+	vaultMu.Unlock()
+	if got, err := readSecret(ref); err != nil || got != "sk-secret" {
+		t.Fatalf("恢复默认口令后读取 = %q, %v", got, err)
+	}
+	if status := ReadVaultStatus(); status.Locked || status.Custom {
+		t.Errorf("状态 = %+v", status)
+	}
+}
+
+func TestStartReportsLockedVault(t *testing.T) {
+	useVault(t, `{}`)
+	ref := sealSecret(t, "sk-secret")
+	cfg := defaultConfig()
+	cfg.Bind = "127.0.0.1:0"
+	cfg.ChatApiKey, cfg.CodexApiKey = ref, ref
+	doc := newConfigFile()
+	doc.Profiles[defaultProfileName] = cfg
+	content, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ConfigPath(), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ChangeVaultPassphrase("", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	vaultMu.Lock()
+	vaultPassphrase = ""
+	vaultMu.Unlock()
+
+	sm := NewServerManager()
+	resp := sm.Start()
+	if resp.Status != "fail" || !strings.Contains(resp.Msg, "密钥库已锁定") {
+		t.Fatalf("Start = %+v", resp)
+	}
+	if status, ok := resp.Data.(ServerStatus); !ok || !status.VaultLocked {
+		t.Errorf("Start 返回的状态 = %+v，期望 vault_locked", resp.Data)
+	}
+	if resp := ReadConfig(); resp.Status != "fail" || !strings.Contains(resp.Msg, "密钥库已锁定") {
+		t.Errorf("ReadConfig = %+v", resp)
+	}
+
+	if err := SetVaultPassphrase("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := sm.Start(); resp.Status != "success" {
+		t.Fatalf("解锁后 Start = %+v", resp)
+	}
+	if resp := sm.Stop(); resp.Status != "success" {
+		t.Errorf("Stop = %+v", resp)
+	}
+}
+
+func TestMergeMaskedSecrets(t *testing.T) {
+	upstreams := func(names ...string) []UpstreamConfig {
+		var ups []UpstreamConfig
+		for _, name := range names {
+			ups = append(ups, UpstreamConfig{Name: name, ApiBase: "https://" + name + ".example.com", ApiKey: "sk-upstream-key-" + name})
+		}
+		return ups
+	}
+
+	tests := []struct {
+		name     string
+		previous func(cfg *config)
+		edit     func(cfg *config)
+		want     func(cfg *config)
+	}{
+		{
+			name:     "删除第一个上游",
+			previous: func(cfg *config) { cfg.ChatUpstreams = upstreams("a", "b") },
+			edit:     func(cfg *config) { cfg.ChatUpstreams = cfg.ChatUpstreams[1:] },
+			want:     func(cfg *config) { cfg.ChatUpstreams = upstreams("b") },
+		},
+		{
+			name:     "调整上游顺序",
+			previous: func(cfg *config) { cfg.CodexUpstreams = upstreams("a", "b", "c") },
+			edit: func(cfg *config) {
+				ups := cfg.CodexUpstreams
+				cfg.CodexUpstreams = []UpstreamConfig{ups[2], ups[0], ups[1]}
+			},
+			want: func(cfg *config) { cfg.CodexUpstreams = upstreams("c", "a", "b") },
+		},
+		{
+			name:     "上游改名",
+			previous: func(cfg *config) { cfg.ChatUpstreams = upstreams("a") },
+			edit:     func(cfg *config) { cfg.ChatUpstreams[0].Name = "renamed" },
+			want: func(cfg *config) {
+				cfg.ChatUpstreams = upstreams("a")
+				cfg.ChatUpstreams[0].Name = "renamed"
+			},
+		},
+		{
+			name: "删除 key 池中的第一个 key",
+			previous: func(cfg *config) {
+				cfg.ChatApiKeys = []string{"sk-pool-key-00001", "sk-pool-key-00002", "sk-pool-key-00003"}
+			},
+			edit: func(cfg *config) { cfg.ChatApiKeys = cfg.ChatApiKeys[1:] },
+			want: func(cfg *config) { cfg.ChatApiKeys = []string{"sk-pool-key-00002", "sk-pool-key-00003"} },
+		},
+		{
+			name: "上游 key 池调整顺序并新增",
+			previous: func(cfg *config) {
+				cfg.CodexUpstreams = []UpstreamConfig{{Name: "a", ApiKeys: []string{"sk-pool-key-00001", "sk-pool-key-00002"}}}
+			},
+			edit: func(cfg *config) {
+				keys := cfg.CodexUpstreams[0].ApiKeys
+				cfg.CodexUpstreams[0].ApiKeys = []string{keys[1], "sk-pool-key-00003", keys[0]}
+			},
+			want: func(cfg *config) {
+				cfg.CodexUpstreams = []UpstreamConfig{{Name: "a", ApiKeys: []string{"sk-pool-key-00002", "sk-pool-key-00003", "sk-pool-key-00001"}}}
+			},
+		},
+		{
+			name:     "短密钥按位置对应",
+			previous: func(cfg *config) { cfg.ChatApiKeys = []string{"short-1", "short-2"} },
+			edit:     func(cfg *config) {},
+			want:     func(cfg *config) { cfg.ChatApiKeys = []string{"short-1", "short-2"} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useVault(t, `{}`)
+
+			plain := defaultConfig()
+			tt.previous(&plain)
+			previous := cloneConfig(plain)
+			if err := mergeSecrets(&previous, config{}); err != nil {
+				t.Fatal(err)
+			}
+
+			// 前端拿到的是掩码，修改后整体回传
+			cfg := maskSecrets(plain)
+			tt.edit(&cfg)
+			if err := mergeSecrets(&cfg, previous); err != nil {
+				t.Fatal(err)
+			}
+			if err := resolveSecrets(&cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			want := cloneConfig(plain)
+			tt.want(&want)
+			got, _ := json.Marshal(cfg)
+			expected, _ := json.Marshal(want)
+			if string(got) != string(expected) {
+				t.Errorf("合并结果\n%s\n期望\n%s", got, expected)
+			}
+		})
+	}
+}
+
+func TestUpdateConfigReturnsMaskedPlaintext(t *testing.T) {
+	useVault(t, `{}`)
+	cfg := defaultConfig()
+	cfg.ChatApiKey = "sk-chat-0123456789abcd"
+	cfg.ChatApiKeys = []string{"sk-pool-0123456789wxyz"}
+	content, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := UpdateConfig(string(content))
+	if resp.Status != "success" {
+		t.Fatalf("UpdateConfig = %+v", resp)
+	}
+	got := resp.Data.(config)
+	if got.ChatApiKey != maskSecret("sk-chat-0123456789abcd") || got.ChatApiKeys[0] != maskSecret("sk-pool-0123456789wxyz") {
+		t.Fatalf("返回的掩码 = %q %q，应由明文生成", got.ChatApiKey, got.ChatApiKeys)
+	}
+
+	// 前端把掩码原样回传后，返回的掩码不变，文件中仍是密钥库引用
+	content, err = json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = UpdateConfig(string(content))
+	if resp.Status != "success" {
+		t.Fatalf("再次 UpdateConfig = %+v", resp)
+	}
+	if again := resp.Data.(config); again.ChatApiKey != got.ChatApiKey || again.ChatApiKeys[0] != got.ChatApiKeys[0] {
+		t.Errorf("再次保存返回的掩码 = %q %q，期望 %q %q", again.ChatApiKey, again.ChatApiKeys, got.ChatApiKey, got.ChatApiKeys)
+	}
+	doc, err := readConfigDocument()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := doc.Profiles[doc.ActiveProfile]
+	if !isSecretRef(saved.ChatApiKey) {
+		t.Fatalf("文件中的 chat_api_key = %q", saved.ChatApiKey)
+	}
+	if plain, err := readSecret(saved.ChatApiKey); err != nil || plain != "sk-chat-0123456789abcd" {
+		t.Errorf("密钥库中的 chat_api_key = %q, %v", plain, err)
+	}
+}
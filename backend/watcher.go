@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// configWatcher 轮询配置文件的修改时间和大小，文件变化时触发回调
+type configWatcher struct {
+	path     string
+	interval time.Duration
+	onChange func()
+	stop     chan struct{}
+	once     sync.Once
+
+	modTime time.Time
+	size    int64
+}
+
+func watchConfig(path string, interval time.Duration, onChange func()) *configWatcher {
+	w := &configWatcher{
+		path:     path,
+		interval: interval,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+	}
+	w.changed()
+
+	go w.loop()
+	return w
+}
+
+func (w *configWatcher) loop() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if w.changed() {
+				w.onChange()
+			}
+		}
+	}
+}
+
+// changed 记录当前文件状态并返回是否与上次不同
+func (w *configWatcher) changed() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	return true
+}
+
+// Close 停止监听，不等待回调结束，可以在回调内部调用
+func (w *configWatcher) Close() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
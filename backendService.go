@@ -6,18 +6,111 @@ import (
 
 type BackendService struct {
 	manager *backend.Manager
+	// onProfilesChanged 在 profile 列表或当前 profile 变化后调用，用于刷新托盘菜单
+	onProfilesChanged func()
+}
+
+func NewBackendService() *BackendService {
+	return &BackendService{
+		manager: backend.NewServerManager(),
+	}
 }
 
 func (g *BackendService) StartServer() backend.ResponseData {
-	g.manager = backend.NewServerManager()
 	return g.manager.Start()
 }
 func (g *BackendService) StopServer() backend.ResponseData {
 	return g.manager.Stop()
 }
+func (g *BackendService) ServerStatus() backend.ResponseData {
+	return backend.ResponseData{
+		Status: "success",
+		Data:   g.manager.Status(),
+		Msg:    "获取服务器状态成功",
+	}
+}
+func (g *BackendService) UpstreamStatus() backend.ResponseData {
+	return backend.ResponseData{
+		Status: "success",
+		Data:   g.manager.UpstreamStatus(),
+		Msg:    "获取上游状态成功",
+	}
+}
 func (g *BackendService) ReadConfig() backend.ResponseData {
 	return backend.ReadConfig()
 }
 func (g *BackendService) UpdateConfig(config string) backend.ResponseData {
-	return backend.UpdateConfig(config)
+	return g.manager.UpdateConfig(config)
+}
+func (g *BackendService) ConfigPath() backend.ResponseData {
+	return backend.ResponseData{
+		Status: "success",
+		Data:   backend.ConfigPath(),
+		Msg:    "获取配置文件路径成功",
+	}
+}
+func (g *BackendService) ConvertConfig(format string) backend.ResponseData {
+	return g.manager.ConvertConfig(format)
+}
+func (g *BackendService) ExportConfig(redact bool) backend.ResponseData {
+	return backend.ExportConfig(redact)
+}
+func (g *BackendService) PreviewImport(bundle string, mode string) backend.ResponseData {
+	return backend.PreviewImport(bundle, mode)
+}
+func (g *BackendService) ImportConfig(bundle string, mode string) backend.ResponseData {
+	return g.profilesChanged(g.manager.ImportConfig(bundle, mode))
+}
+func (g *BackendService) ListProfiles() backend.ResponseData {
+	return backend.ListProfiles()
+}
+func (g *BackendService) CreateProfile(name string) backend.ResponseData {
+	return g.profilesChanged(backend.CreateProfile(name))
+}
+func (g *BackendService) CloneProfile(source string, name string) backend.ResponseData {
+	return g.profilesChanged(backend.CloneProfile(source, name))
+}
+func (g *BackendService) DeleteProfile(name string) backend.ResponseData {
+	return g.profilesChanged(backend.DeleteProfile(name))
+}
+func (g *BackendService) ActivateProfile(name string) backend.ResponseData {
+	return g.profilesChanged(g.manager.ActivateProfile(name))
+}
+
+func (g *BackendService) profilesChanged(resp backend.ResponseData) backend.ResponseData {
+	if g.onProfilesChanged != nil {
+		g.onProfilesChanged()
+	}
+	return resp
+}
+func (g *BackendService) UnlockVault(passphrase string) backend.ResponseData {
+	if err := backend.SetVaultPassphrase(passphrase); err != nil {
+		return backend.ResponseData{
+			Status: "fail",
+			Msg:    "密钥库解锁失败: " + err.Error(),
+		}
+	}
+	return backend.ResponseData{
+		Status: "success",
+		Msg:    "密钥库已解锁",
+	}
+}
+func (g *BackendService) ChangeVaultPassphrase(oldPassphrase string, newPassphrase string) backend.ResponseData {
+	if err := backend.ChangeVaultPassphrase(oldPassphrase, newPassphrase); err != nil {
+		return backend.ResponseData{
+			Status: "fail",
+			Msg:    "修改密钥库口令失败: " + err.Error(),
+		}
+	}
+	return backend.ResponseData{
+		Status: "success",
+		Msg:    "密钥库口令已修改",
+	}
+}
+func (g *BackendService) VaultStatus() backend.ResponseData {
+	return backend.ResponseData{
+		Status: "success",
+		Data:   backend.ReadVaultStatus(),
+		Msg:    "获取密钥库状态成功",
+	}
 }
@@ -9,6 +9,105 @@ import {Call as $Call, Create as $Create} from "@wailsio/runtime";
 // @ts-ignore: Unused imports
 import * as backend$0 from "./backend/models.js";
 
+export function ActivateProfile(name: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(1983257073, name) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function ChangeVaultPassphrase(oldPassphrase: string, newPassphrase: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(2627568379, oldPassphrase, newPassphrase) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function CloneProfile(source: string, name: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(1763483763, source, name) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function ConfigPath(): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(1949607932) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function ConvertConfig(format: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(638025002, format) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function CreateProfile(name: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(713696250, name) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function DeleteProfile(name: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(54160323, name) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function ExportConfig(redact: boolean): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(1151947709, redact) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function ImportConfig(bundle: string, mode: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(2700051022, bundle, mode) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function ListProfiles(): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(4287772391) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function PreviewImport(bundle: string, mode: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(3968852554, bundle, mode) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
 export function ReadConfig(): Promise<backend$0.ResponseData> & { cancel(): void } {
     let $resultPromise = $Call.ByID(2715560523) as any;
     let $typingPromise = $resultPromise.then(($result: any) => {
@@ -18,6 +117,15 @@ export function ReadConfig(): Promise<backend$0.ResponseData> & { cancel(): void
     return $typingPromise;
 }
 
+export function ServerStatus(): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(3503293760) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
 export function StartServer(): Promise<backend$0.ResponseData> & { cancel(): void } {
     let $resultPromise = $Call.ByID(445882648) as any;
     let $typingPromise = $resultPromise.then(($result: any) => {
@@ -36,6 +144,15 @@ export function StopServer(): Promise<backend$0.ResponseData> & { cancel(): void
     return $typingPromise;
 }
 
+export function UnlockVault(passphrase: string): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(220154619, passphrase) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
 export function UpdateConfig(config: string): Promise<backend$0.ResponseData> & { cancel(): void } {
     let $resultPromise = $Call.ByID(2758254294, config) as any;
     let $typingPromise = $resultPromise.then(($result: any) => {
@@ -45,5 +162,23 @@ export function UpdateConfig(config: string): Promise<backend$0.ResponseData> &
     return $typingPromise;
 }
 
+export function UpstreamStatus(): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(2029199694) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
+export function VaultStatus(): Promise<backend$0.ResponseData> & { cancel(): void } {
+    let $resultPromise = $Call.ByID(4010541421) as any;
+    let $typingPromise = $resultPromise.then(($result: any) => {
+        return $$createType0($result);
+    }) as any;
+    $typingPromise.cancel = $resultPromise.cancel.bind($resultPromise);
+    return $typingPromise;
+}
+
 // Private type creation functions
 const $$createType0 = backend$0.ResponseData.createFrom;
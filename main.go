@@ -3,9 +3,12 @@ package main
 import (
 	"embed"
 	_ "embed"
+	"flag"
 	"log"
 	"runtime"
 
+	"override-gui/backend"
+
 	"github.com/wailsapp/wails/v3/pkg/application"
 	"github.com/wailsapp/wails/v3/pkg/icons"
 )
@@ -22,17 +25,21 @@ var assets embed.FS
 // and starts a goroutine that emits a time-based event every second. It subsequently runs the application and
 // logs any error that might occur.
 func main() {
+	configPath := flag.String("config", "", "配置文件路径")
+	flag.Parse()
+	backend.SetConfigPath(*configPath)
 
 	// Create a new Wails application by providing the necessary options.
 	// Variables 'Name' and 'Description' are for application metadata.
 	// 'Assets' configures the asset server with the 'FS' variable pointing to the frontend files.
 	// 'Bind' is a list of Go struct instances. The frontend has access to the methods of these instances.
 	// 'Mac' options tailor the application when running an macOS.
+	backendService := NewBackendService()
 	app := application.New(application.Options{
 		Name:        "override-gui",
 		Description: "Override GUI",
 		Services: []application.Service{
-			application.NewService(&BackendService{}),
+			application.NewService(backendService),
 		},
 		Assets: application.AssetOptions{
 			Handler: application.AssetFileServerFS(assets),
@@ -78,11 +85,17 @@ func main() {
 	})
 
 	// Support for menu
-	trayMenu := app.NewMenu()
-	trayMenu.Add("退出").OnClick(func(_ *application.Context) {
-		app.Quit()
+	backendService.onProfilesChanged = func() {
+		systemTray.SetMenu(newTrayMenu(app, backendService))
+		app.Events.Emit(&application.WailsEvent{Name: "profiles:changed"})
+	}
+	systemTray.SetMenu(newTrayMenu(app, backendService))
+
+	// 上游熔断状态变化时刷新托盘菜单并通知窗口
+	backendService.manager.SetBreakerListener(func(status backend.BreakerStatus) {
+		systemTray.SetMenu(newTrayMenu(app, backendService))
+		app.Events.Emit(&application.WailsEvent{Name: "upstream:breaker", Data: status})
 	})
-	systemTray.SetMenu(trayMenu)
 
 	// Run the application. This blocks until the application has been exited.
 	err := app.Run()
@@ -92,3 +105,43 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// newTrayMenu 构建托盘菜单，profile 以单选项列出，点击即可切换
+func newTrayMenu(app *application.App, service *BackendService) *application.Menu {
+	trayMenu := app.NewMenu()
+
+	if list, ok := service.ListProfiles().Data.(backend.ProfileList); ok && len(list.Profiles) > 0 {
+		for _, name := range list.Profiles {
+			name := name
+			trayMenu.AddRadio(name, name == list.Active).OnClick(func(_ *application.Context) {
+				if resp := service.ActivateProfile(name); resp.Status == "fail" {
+					log.Println("切换 profile 失败:", resp.Msg)
+				}
+			})
+		}
+		trayMenu.AddSeparator()
+	}
+
+	if statuses, ok := service.UpstreamStatus().Data.([]backend.BreakerStatus); ok {
+		degraded := false
+		for _, status := range statuses {
+			if status.State == backend.BreakerClosed {
+				continue
+			}
+			label := "上游熔断: " + status.Upstream
+			if status.State == backend.BreakerHalfOpen {
+				label = "上游恢复中: " + status.Upstream
+			}
+			trayMenu.Add(label).SetEnabled(false)
+			degraded = true
+		}
+		if degraded {
+			trayMenu.AddSeparator()
+		}
+	}
+
+	trayMenu.Add("退出").OnClick(func(_ *application.Context) {
+		app.Quit()
+	})
+	return trayMenu
+}